@@ -0,0 +1,188 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+)
+
+// AttributesKey is the name of the annotation with the attributes of the keys set with SetAttributes
+// The value is a JSON object with the keys and their attributes, e.g. {"key":{"mode":256,"uid":1000}},
+// the attributes of the secret itself are stored with the empty key.
+const AttributesKey = "attributes"
+
+// Attributes are the mode, owner and times of a key or secret, nil fields are not set
+type Attributes struct {
+	Mode  *fs.FileMode `json:"mode,omitempty"`
+	UID   *int         `json:"uid,omitempty"`
+	GID   *int         `json:"gid,omitempty"`
+	Atime *time.Time   `json:"atime,omitempty"`
+	// Mtime is stored with the modification times of the keys, see ModTimesKey
+	Mtime *time.Time `json:"-"`
+}
+
+// merge returns a with the fields set in o replaced
+func (a Attributes) merge(o Attributes) Attributes {
+	if o.Mode != nil {
+		a.Mode = o.Mode
+	}
+
+	if o.UID != nil {
+		a.UID = o.UID
+	}
+
+	if o.GID != nil {
+		a.GID = o.GID
+	}
+
+	if o.Atime != nil {
+		a.Atime = o.Atime
+	}
+
+	return a
+}
+
+func (a Attributes) isZero() bool {
+	return a.Mode == nil && a.UID == nil && a.GID == nil && a.Atime == nil
+}
+
+// Attributer is implemented by backends which store the attributes of keys and secrets
+type Attributer interface {
+	// SetAttributes sets the fields set in a on the attributes of the key of m, of the secret if the key is empty
+	SetAttributes(ctx context.Context, m Metadata, a Attributes) error
+}
+
+var _ Attributer = (*backend)(nil)
+
+// SetAttributes sets the fields set in a on the attributes of the key of m (Attributer)
+// The attributes are stored in the annotation attributes, the modification time with the modification times
+// of the keys, with a JSON merge patch conditional on the resourceVersion. The data and the modification time
+// of the secret are not changed. The attributes of immutable secrets can be set as well. Get and Update report
+// the attributes with Secret.SetAttributes and Secret.SetModTimes. Removed keys lose their attributes.
+func (b *backend) SetAttributes(ctx context.Context, m Metadata, a Attributes) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ks, err := b.get(ctx, m)
+		if err != nil {
+			return err
+		}
+
+		if m.Key() != "" {
+			refs, err := chunkRefs(ks)
+			if err != nil {
+				return err
+			}
+
+			// keys stored in chunks are not in the data of the secret
+			_, ok := ks.Data[m.Key()]
+			if _, chunked := refs[m.Key()]; !ok && !chunked {
+				return fmt.Errorf("%w: key %s of %s", syscall.ENOENT, m.Key(), m.Secret())
+			}
+		}
+
+		attrs, err := attributes(ks)
+		if err != nil {
+			return err
+		}
+
+		attrs[m.Key()] = attrs[m.Key()].merge(a)
+		if attrs[m.Key()].isZero() {
+			delete(attrs, m.Key())
+		}
+
+		annotations := map[string]interface{}{}
+
+		annotations[AttributesKey], err = jsonAnnotation(attrs)
+		if err != nil {
+			return err
+		}
+
+		if a.Mtime != nil {
+			mtimes, err := modTimes(ks)
+			if err != nil {
+				return err
+			}
+
+			mtimes[m.Key()] = formatTime(*a.Mtime)
+
+			annotations[ModTimesKey], err = jsonAnnotation(mtimes)
+			if err != nil {
+				return err
+			}
+		}
+
+		p, err := annotationPatch(ks.ResourceVersion, annotations)
+		if err != nil {
+			return err
+		}
+
+		// the patch is idempotent because of the resourceVersion precondition
+		return b.retry(ctx, func(ctx context.Context) error {
+			ks, err := b.r.patch(ctx, ks.Namespace, ks.Name, p)
+			if err == nil {
+				b.cached(ks)
+			}
+
+			return err
+		})
+	})
+
+	if apierr.IsConflict(err) {
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	}
+
+	return err
+}
+
+// attributes returns the attributes of the keys of ks
+func attributes(ks *corev1.Secret) (map[string]Attributes, error) {
+	attrs := make(map[string]Attributes)
+
+	v, ok := ks.Annotations[AttributesKey]
+	if !ok {
+		return attrs, nil
+	}
+
+	if err := json.Unmarshal([]byte(v), &attrs); err != nil {
+		return nil, fmt.Errorf("%w: annotation %s of %s: %v", syscall.EIO, AttributesKey, ks.Name, err)
+	}
+
+	return attrs, nil
+}
+
+// pruneAttributes removes the attributes of the keys which are not in the data of ks
+func pruneAttributes(ks *corev1.Secret) error {
+	attrs, err := attributes(ks)
+	if err != nil {
+		return err
+	}
+
+	for key := range attrs {
+		if _, ok := ks.Data[key]; key != "" && !ok {
+			delete(attrs, key)
+		}
+	}
+
+	v, err := jsonAnnotation(attrs)
+	if err != nil {
+		return err
+	}
+
+	if v == nil {
+		delete(ks.Annotations, AttributesKey)
+	} else {
+		ks.Annotations[AttributesKey] = v.(string)
+	}
+
+	return nil
+}
@@ -0,0 +1,1170 @@
+// Package backend provides CRUD for the secrets and config maps
+// Other stores can be used with secfs by implementing Backend, see secfs.NewWithBackend.
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// DefaultRequestTimeout for k8s requests
+	DefaultRequestTimeout = 5 * time.Second
+	// AnnotationKey is the name of the secfs annotation
+	AnnotationKey = "secfs"
+	// AnnotationValue is the secfs version
+	AnnotationValue = "v1"
+	// ModTimeKey is the name of the modification time annotation
+	// The times are formatted with time.RFC3339Nano, times with seconds only are read as well.
+	ModTimeKey = "modtime"
+	// ModTimesKey is the name of the annotation with the modification times of the keys
+	// The value is a JSON object with the keys and their modification time, e.g. {"key":"2024-01-02T03:04:05.123456789Z"}.
+	// Keys without a modification time (e.g. written by other clients) are as old as the secret,
+	// the time of the last removal of a key or set with SetAttributes on the secret is stored with the empty key.
+	ModTimesKey = "modtimes"
+)
+
+var (
+	// ErrNotManaged for secrets not managed with secfs
+	ErrNotManaged = errors.New("not managed with secfs")
+	// ErrConflict for keys modified concurrently since they have been read
+	ErrConflict = errors.New("modified concurrently")
+)
+
+// Metadata is the interface for basic metadata information
+type Metadata interface {
+	Namespace() string
+	Secret() string
+	Key() string
+}
+
+// Secret is the interface that abstracts the Kubernetes secret
+type Secret interface {
+	Metadata
+
+	Value() []byte
+	Delete() bool // delete Key() from map
+
+	Data() map[string][]byte
+	SetData(map[string][]byte)
+
+	SetTime(time.Time)
+
+	// SetModTimes sets the modification times of the keys, see ModTimesKey
+	SetModTimes(map[string]time.Time)
+
+	// SetSize sets the encoded size of the secret, see EncodedSize
+	// the size is negative if it is not limited (chunking)
+	SetSize(int)
+
+	// SecretType of the secret, empty for the default type
+	SecretType() corev1.SecretType
+	SetSecretType(corev1.SecretType)
+
+	// ResourceVersion of the secret the data has been read from, empty if unknown
+	ResourceVersion() string
+	SetResourceVersion(string)
+
+	// SetImmutable sets if the secret is immutable, see Replacer
+	SetImmutable(bool)
+
+	// SetAttributes sets the attributes of the keys of the secret, see Attributer
+	SetAttributes(map[string]Attributes)
+}
+
+// Backend is the interface that groups the basic Create, Get, Update and Delete methods.
+// Namespaces and List return the entries of the root and namespace directories.
+// The context is passed to the Kubernetes API requests, the request timeout is applied on top of it.
+//
+// Implementations other than the Kubernetes backends have to follow the same contract:
+//   - Get and Update set the data, type, resourceVersion, immutability, attributes and modification time of the secret on s
+//   - Update sets or removes (Delete) the key of s, other keys are preserved
+//   - Update fails with syscall.EPERM for immutable secrets
+//   - Delete removes the whole secret, a secret which does not exist is not an error
+//   - Rename fails with syscall.EEXIST if the new secret exists
+//   - secrets, namespaces or keys which do not exist are reported with an error wrapping syscall.ENOENT
+//   - other failures should wrap the matching syscall.Errno, ErrConflict or ErrRequiredKey
+type Backend interface {
+	Create(context.Context, Secret) error
+	Get(context.Context, Secret) error
+	Update(context.Context, Secret) error
+	Delete(context.Context, Secret) error
+	Rename(context.Context, Metadata, Metadata) error
+	Namespaces(ctx context.Context) ([]string, error)
+	List(ctx context.Context, namespace string) ([]string, error)
+}
+
+// backend implements the communication with Kubernetes
+type backend struct {
+	c      kubernetes.Interface
+	r      resource
+	prefix string
+	suffix string
+	labels map[string]string
+
+	secretType corev1.SecretType
+	chunking   bool
+	immutable  bool
+	keys       KeyProvider
+
+	revisions   int
+	historySize int
+
+	ignoreAnnotation bool
+
+	mu       sync.Mutex
+	timeout  time.Duration
+	attempts int
+	backoff  time.Duration
+
+	cache           *secretCache
+	cacheCtx        context.Context
+	cacheSelector   map[string]string
+	cacheNamespaces []string
+}
+
+// New returns a Backend storing the data in secrets
+func New(c kubernetes.Interface, opts ...Option) Backend {
+	return newBackend(c, secrets{c: c}, opts...)
+}
+
+// NewConfigMap returns a Backend storing the data in config maps
+// Values which are valid UTF-8 are stored in Data, all others in BinaryData.
+// The options apply to the config maps like they do to secrets.
+func NewConfigMap(c kubernetes.Interface, opts ...Option) Backend {
+	return newBackend(c, configMaps{c: c}, opts...)
+}
+
+func newBackend(c kubernetes.Interface, r resource, opts ...Option) Backend {
+	b := &backend{
+		c:        c,
+		r:        r,
+		timeout:  DefaultRequestTimeout,
+		attempts: DefaultRetryAttempts,
+		backoff:  DefaultRetryBackoff,
+	}
+
+	for _, option := range opts {
+		option(b)
+	}
+
+	if b.cacheCtx != nil {
+		b.cache = newSecretCache(b.cacheCtx, c, r, b.cacheSelector, b.cacheNamespaces)
+	}
+
+	return b
+}
+
+// Create secret in backend
+// The secret is created with the type of s or the configured type if s has none,
+// ErrRequiredKey is returned if a key required by the type is missing.
+// Secrets larger than MaxSecretSize are rejected with EFBIG before they are sent,
+// with chunking the largest keys are stored in chunk secrets instead.
+// With encryption all values are encrypted with the current key.
+// The secret is created immutable if configured.
+func (b *backend) Create(ctx context.Context, s Secret) error {
+	t := s.SecretType()
+	if t == "" {
+		t = b.secretType
+	}
+
+	if err := ValidateData(t, s.Data()); err != nil {
+		return err
+	}
+
+	ks := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      b.internalName(s.Secret()),
+			Namespace: s.Namespace(),
+			Labels:    b.labels,
+			Annotations: map[string]string{
+				AnnotationKey: AnnotationValue,
+			},
+		},
+		Type: t,
+		Data: s.Data(),
+	}
+
+	if b.immutable {
+		immutable := true
+		ks.Immutable = &immutable
+	}
+
+	setCurrentTimes(ks)
+
+	if err := b.encryptAll(ks); err != nil {
+		return err
+	}
+
+	return b.create(ctx, ks)
+}
+
+// create creates ks, with chunking the largest keys are stored in chunk secrets if ks is too large
+func (b *backend) create(ctx context.Context, ks *corev1.Secret) error {
+	var created []string
+
+	if b.chunking {
+		var err error
+
+		created, err = b.split(ctx, ks)
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.size(ks) > MaxSecretSize {
+		return syscall.EFBIG
+	}
+
+	err := b.request(ctx, func(ctx context.Context) error {
+		ks, err := b.r.create(ctx, ks)
+		if err == nil {
+			b.cached(ks)
+		}
+
+		return err
+	})
+	if err != nil {
+		_ = b.deleteChunks(ctx, ks.Namespace, created)
+	}
+
+	return err
+}
+
+// Get secret from backend
+// The secret is read from the cache if configured, encrypted values are decrypted.
+func (b *backend) Get(ctx context.Context, s Secret) error {
+	ks, err := b.read(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	data, err := b.decode(ctx, ks)
+	if err != nil {
+		return err
+	}
+
+	b.set(s, ks, data)
+
+	return nil
+}
+
+// set sets the data of the decoded secret data and the metadata of ks on s
+func (b *backend) set(s Secret, ks, data *corev1.Secret) {
+	s.SetData(data.Data)
+	s.SetSecretType(ks.Type)
+	s.SetResourceVersion(ks.ResourceVersion)
+	s.SetImmutable(isImmutable(ks))
+	s.SetTime(getTime(ks))
+	s.SetModTimes(getModTimes(ks))
+
+	// malformed attributes are reported as not set
+	attrs, _ := attributes(ks)
+	s.SetAttributes(attrs)
+
+	if b.chunking {
+		s.SetSize(-1)
+	} else {
+		s.SetSize(b.size(ks))
+	}
+}
+
+// Update secret in backend
+// Only the key of s and the modification time are changed with a JSON merge patch, other
+// keys and fields modified concurrently by other writers are preserved.
+// If the secret has been modified since s has been read, the update is applied as long as
+// the key itself has not been changed concurrently, otherwise ErrConflict is returned.
+// The patch is conditional on the resourceVersion the decision has been based on,
+// conflicts reported by the API server are retried with the current secret.
+// Keys required by the type of the secret can not be deleted (ErrRequiredKey).
+// Updates growing the secret beyond MaxSecretSize are rejected with EFBIG before they are sent,
+// with chunking the value is stored in chunk secrets instead. New chunks are created before
+// the secret refers to them and the replaced chunks are removed afterwards.
+// With encryption the value is encrypted with the current key, so keys are re-encrypted lazily on write.
+// Immutable secrets are rejected with EPERM before anything is sent, see Replacer.
+// With history the previous data is recorded as a revision before the key is changed, see Historian.
+func (b *backend) Update(ctx context.Context, s Secret) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ks, err := b.get(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		if isImmutable(ks) {
+			return syscall.EPERM
+		}
+
+		data, err := b.decode(ctx, ks)
+		if err != nil {
+			return err
+		}
+
+		if s.Delete() {
+			if err := ValidateDelete(ks.Type, data.Data, s.Key()); err != nil {
+				return err
+			}
+		}
+
+		changed, err := merge(data, s)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			var value []byte // nil removes the key
+
+			if !s.Delete() {
+				value = s.Value()
+				if value == nil {
+					value = []byte{}
+				}
+			}
+
+			if err := b.record(ctx, ks); err != nil {
+				return err
+			}
+
+			ks, err = b.patch(ctx, ks, keyChange{key: s.Key(), value: value})
+			if err != nil {
+				return err
+			}
+
+			data, err = b.decode(ctx, ks)
+			if err != nil {
+				return err
+			}
+		}
+
+		b.set(s, ks, data)
+
+		return nil
+	})
+
+	if apierr.IsConflict(err) {
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	}
+
+	return err
+}
+
+// keyChange sets key to value or removes the key (value nil)
+type keyChange struct {
+	key   string
+	value []byte
+	from  string // the key takes the modification time and attributes of from (rename)
+	keep  bool   // the modification times are kept, the plaintext has not changed (re-encryption)
+}
+
+// patch applies the changes to ks with a JSON merge patch conditional on the resourceVersion of ks
+// returns the patched secret
+func (b *backend) patch(ctx context.Context, ks *corev1.Secret, changes ...keyChange) (*corev1.Secret, error) {
+	meta, err := newPatchMeta(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, name := ks.Namespace, ks.Name
+
+	// the chunks created for the changes are removed unless the patch succeeds
+	var created, old []string
+
+	patched := false
+
+	defer func() {
+		if !patched {
+			_ = b.deleteChunks(ctx, namespace, created)
+		}
+	}()
+
+	// the size of the secret is checked with the changes applied before
+	w := ks.DeepCopy()
+	data := make(map[string][]byte, len(changes))
+
+	for _, c := range changes {
+		meta.track(c)
+
+		value := c.value
+
+		if b.keys != nil && value != nil {
+			value, meta.enc[c.key], err = b.encrypt(ks.Type, c.key, value)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			delete(meta.enc, c.key)
+		}
+
+		old = append(old, meta.refs[c.key]...)
+		delete(meta.refs, c.key)
+
+		var names []string
+
+		value, names, err = b.store(ctx, w, c.key, value)
+		if err != nil {
+			return nil, err
+		}
+
+		if names != nil {
+			created = append(created, names...)
+			meta.refs[c.key] = names
+		}
+
+		data[c.key] = value
+	}
+
+	annotations, err := meta.annotations()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := dataPatch(b.r, data, ks.ResourceVersion, annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	// the patch is idempotent because of the resourceVersion precondition
+	err = b.retry(ctx, func(ctx context.Context) error {
+		var err error
+		ks, err = b.r.patch(ctx, namespace, name, p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	patched = true
+
+	b.cached(ks)
+
+	// the chunks of the previous values are not referenced anymore
+	_ = b.deleteChunks(ctx, namespace, old)
+
+	return ks, nil
+}
+
+// store applies value of key to w, values which do not fit into the secret are written to chunks
+// returns the value to patch (nil for chunked values) and the names of the chunks
+func (b *backend) store(ctx context.Context, w *corev1.Secret, key string, value []byte) ([]byte, []string, error) {
+	var names []string
+
+	if b.resize(w, key, value) > MaxSecretSize {
+		if !b.chunking {
+			return nil, nil, syscall.EFBIG
+		}
+
+		var err error
+
+		names, err = b.writeChunks(ctx, w.Namespace, w.Name, key, value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		value = nil
+	}
+
+	if value == nil {
+		delete(w.Data, key)
+	} else {
+		w.Data[key] = value
+	}
+
+	return value, names, nil
+}
+
+// patchMeta are the annotations of a secret maintained by patch
+type patchMeta struct {
+	refs   map[string][]string
+	enc    map[string]encryption
+	attrs  map[string]Attributes
+	mtimes map[string]string
+
+	// the annotations existed before the patch
+	chunked, encrypted, attributed bool
+
+	now     string
+	touched bool
+}
+
+func newPatchMeta(ks *corev1.Secret) (*patchMeta, error) {
+	refs, err := chunkRefs(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := encryptions(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := attributes(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	mtimes, err := modTimes(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &patchMeta{
+		refs:       refs,
+		enc:        enc,
+		attrs:      attrs,
+		mtimes:     mtimes,
+		chunked:    len(refs) > 0,
+		encrypted:  len(enc) > 0,
+		attributed: len(attrs) > 0,
+		now:        currentTime(),
+	}, nil
+}
+
+// track updates the modification times and attributes for change c
+func (m *patchMeta) track(c keyChange) {
+	switch {
+	case c.value == nil:
+		// the removal of a key is the modification of the secret itself (empty key), removed keys lose their attributes
+		delete(m.mtimes, c.key)
+		delete(m.attrs, c.key)
+		m.mtimes[""] = m.now
+	case c.keep:
+	case c.from != "":
+		m.mtimes[c.key] = m.mtimes[c.from]
+		m.attrs[c.key] = m.attrs[c.from]
+
+		if _, ok := m.mtimes[c.from]; !ok {
+			delete(m.mtimes, c.key)
+		}
+
+		if _, ok := m.attrs[c.from]; !ok {
+			delete(m.attrs, c.key)
+		}
+	default:
+		m.mtimes[c.key] = m.now
+	}
+
+	m.touched = m.touched || !c.keep
+}
+
+// annotations returns the annotations to patch
+func (m *patchMeta) annotations() (map[string]interface{}, error) {
+	annotations := map[string]interface{}{}
+
+	if m.touched {
+		annotations[ModTimeKey] = m.now
+	}
+
+	err := setAnnotation(annotations, ModTimesKey, m.mtimes, m.touched)
+	if err == nil {
+		err = setAnnotation(annotations, AttributesKey, m.attrs, m.attributed)
+	}
+
+	if err == nil {
+		err = setAnnotation(annotations, EncryptionKey, m.enc, m.encrypted)
+	}
+
+	if err == nil {
+		err = setAnnotation(annotations, ChunksKey, m.refs, m.chunked)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return annotations, nil
+}
+
+// Delete secret in backend
+// The chunk, history and backup secrets of the secret are removed as well.
+func (b *backend) Delete(ctx context.Context, s Secret) error {
+	ks, err := b.get(ctx, s)
+
+	if apierr.IsNotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := b.delete(ctx, s); err != nil {
+		return err
+	}
+
+	if err := b.deleteHistory(ctx, ks); err != nil {
+		return err
+	}
+
+	if err := b.deleteBackupOf(ctx, ks); err != nil {
+		return err
+	}
+
+	return b.deleteSecretChunks(ctx, ks)
+}
+
+// deleteSecretChunks removes all chunk secrets of ks
+func (b *backend) deleteSecretChunks(ctx context.Context, ks *corev1.Secret) error {
+	refs, err := chunkRefs(ks)
+	if err != nil {
+		return err
+	}
+
+	for _, names := range refs {
+		if err := b.deleteChunks(ctx, ks.Namespace, names); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rename secret in backend
+func (b *backend) Rename(ctx context.Context, o, n Metadata) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, err := b.get(ctx, o)
+	// source not found or backend error
+	if err != nil {
+		return err
+	}
+
+	_, err = b.get(ctx, n)
+	// target already exists
+	if err == nil {
+		return syscall.EEXIST
+	}
+	// backend error
+	if !apierr.IsNotFound(err) {
+		return err
+	}
+
+	// rename, the keys stored in chunks are stored in new chunks of the new secret
+	// encrypted values are copied as they are, the name of the secret is not authenticated
+	ks, err := b.stitch(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	ks = ks.DeepCopy()
+	ks.Name = b.internalName(n.Secret())
+	ks.Namespace = n.Namespace()
+	ks.ResourceVersion = ""
+	delete(ks.Annotations, ChunksKey)
+	setCurrentTime(ks)
+
+	// create new secret
+	if err := b.create(ctx, ks); err != nil {
+		return err
+	}
+
+	// the history and a backup left by Replace move with the secret
+	if err := b.renameHistory(ctx, s, ks); err != nil {
+		return err
+	}
+
+	if err := b.renameCompanion(ctx, s, ks, backupKind, BackupOfKey); err != nil {
+		return err
+	}
+
+	// delete old secret
+	if err := b.delete(ctx, o); err != nil {
+		return err
+	}
+
+	return b.deleteSecretChunks(ctx, s)
+}
+
+// KeyRenamer is implemented by backends which can rename a key within a secret with a single change
+type KeyRenamer interface {
+	// RenameKey renames the key of o to the key of n, both in the secret of o, an existing key n is replaced
+	RenameKey(ctx context.Context, o, n Metadata) error
+}
+
+var _ KeyRenamer = (*backend)(nil)
+
+// RenameKey renames the key of o to the key of n with a single JSON merge patch (KeyRenamer)
+// The key keeps its modification time and attributes, watchers observe the rename as one change.
+// n has to refer to the secret of o (EINVAL). Immutable secrets are rejected with EPERM, keys required
+// by the type of the secret with ErrRequiredKey. With history the previous data is recorded as a revision.
+func (b *backend) RenameKey(ctx context.Context, o, n Metadata) error {
+	if o.Namespace() != n.Namespace() || o.Secret() != n.Secret() || o.Key() == "" || n.Key() == "" {
+		return syscall.EINVAL
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ks, err := b.get(ctx, o)
+		if err != nil {
+			return err
+		}
+
+		if isImmutable(ks) {
+			return syscall.EPERM
+		}
+
+		data, err := b.decode(ctx, ks)
+		if err != nil {
+			return err
+		}
+
+		value, ok := data.Data[o.Key()]
+		if !ok {
+			return fmt.Errorf("%w: key %s of %s", syscall.ENOENT, o.Key(), o.Secret())
+		}
+
+		if o.Key() == n.Key() {
+			return nil
+		}
+
+		if err := ValidateDelete(ks.Type, data.Data, o.Key()); err != nil {
+			return err
+		}
+
+		if value == nil {
+			value = []byte{}
+		}
+
+		if err := b.record(ctx, ks); err != nil {
+			return err
+		}
+
+		_, err = b.patch(ctx, ks,
+			keyChange{key: n.Key(), value: value, from: o.Key()},
+			keyChange{key: o.Key()},
+		)
+
+		return err
+	})
+
+	if apierr.IsConflict(err) {
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	}
+
+	return err
+}
+
+// Namespaces returns the names of all namespaces
+func (b *backend) Namespaces(ctx context.Context) ([]string, error) {
+	var l *corev1.NamespaceList
+
+	err := b.retry(ctx, func(ctx context.Context) error {
+		var err error
+		l, err = b.c.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(l.Items))
+	for i := range l.Items {
+		names = append(names, l.Items[i].Name)
+	}
+
+	return names, nil
+}
+
+// List returns the external names of all secrets in namespace managed with secfs
+// The namespace is checked for existence, if the namespace can not be read because
+// of missing permissions it is assumed to exist.
+func (b *backend) List(ctx context.Context, namespace string) ([]string, error) {
+	err := b.retry(ctx, func(ctx context.Context) error {
+		_, err := b.c.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		return err
+	})
+	if err != nil && !apierr.IsForbidden(err) {
+		return nil, err
+	}
+
+	secrets, err := b.list(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(secrets))
+
+	for i := range secrets {
+		if b.listed(&secrets[i]) {
+			names = append(names, b.externalName(secrets[i].Name))
+		}
+	}
+
+	return names, nil
+}
+
+// listed checks if ks is a secret managed with secfs, chunk, history and backup secrets are not listed
+func (b *backend) listed(ks *corev1.Secret) bool {
+	if !b.checkAnnotation(ks) || !b.checkName(ks.Name) {
+		return false
+	}
+
+	if _, ok := ks.Annotations[ChunkOfKey]; ok {
+		return false
+	}
+
+	if _, ok := ks.Annotations[HistoryOfKey]; ok {
+		return false
+	}
+
+	_, ok := ks.Annotations[BackupOfKey]
+
+	return !ok
+}
+
+// list returns the secrets in namespace with the configured labels
+// the secrets are read from the cache if configured and the cache holds all secrets with the labels
+func (b *backend) list(ctx context.Context, namespace string) ([]corev1.Secret, error) {
+	selector := labels.SelectorFromSet(b.labels)
+
+	if b.cache != nil && b.cache.covers(b.labels) {
+		secrets, ok, err := b.cache.list(ctx, namespace, selector)
+		if ok || err != nil {
+			return secrets, err
+		}
+	}
+
+	var secrets []corev1.Secret
+
+	err := b.retry(ctx, func(ctx context.Context) error {
+		var err error
+		secrets, _, err = b.r.list(ctx, namespace, metav1.ListOptions{
+			LabelSelector: selector.String(),
+		})
+
+		return err
+	})
+
+	return secrets, err
+}
+
+// read returns the secret from the cache if configured and the secret is cached,
+// from the API server otherwise
+func (b *backend) read(ctx context.Context, s Metadata) (*corev1.Secret, error) {
+	if b.cache == nil {
+		return b.get(ctx, s)
+	}
+
+	ks, ok, err := b.cache.get(ctx, s.Namespace(), b.internalName(s.Secret()))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return b.get(ctx, s)
+	}
+
+	return b.checkSecret(ks)
+}
+
+func (b *backend) get(ctx context.Context, s Metadata) (*corev1.Secret, error) {
+	var ks *corev1.Secret
+
+	err := b.retry(ctx, func(ctx context.Context) error {
+		var err error
+		ks, err = b.r.get(ctx, s.Namespace(), b.internalName(s.Secret()))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.cached(ks)
+
+	return b.checkSecret(ks)
+}
+
+// checkSecret checks if ks is managed with secfs and initializes the data
+func (b *backend) checkSecret(ks *corev1.Secret) (*corev1.Secret, error) {
+	if ks.Data == nil {
+		ks.Data = make(map[string][]byte)
+	}
+
+	if !b.checkAnnotation(ks) {
+		return nil, ErrNotManaged
+	}
+
+	return ks, nil
+}
+
+// cached stores the secret read from or written to the API server in the cache
+func (b *backend) cached(ks *corev1.Secret) {
+	if b.cache != nil {
+		b.cache.update(ks)
+	}
+}
+
+// delete removes the secret, a secret which does not exist (anymore) is not an error
+func (b *backend) delete(ctx context.Context, s Metadata) error {
+	err := b.retry(ctx, func(ctx context.Context) error {
+		return b.r.delete(ctx, s.Namespace(), b.internalName(s.Secret()), metav1.DeleteOptions{})
+	})
+
+	if b.cache != nil && (err == nil || apierr.IsNotFound(err)) {
+		b.cache.delete(s.Namespace(), b.internalName(s.Secret()))
+	}
+
+	if apierr.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// internal
+
+// internalName is the name of the secret in the backend
+func (b *backend) internalName(name string) string {
+	return fmt.Sprintf("%s%s%s", b.prefix, name, b.suffix)
+}
+
+// externalName is the name of the secret used in path
+func (b *backend) externalName(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, b.prefix), b.suffix)
+}
+
+// checkName checks if name carries the configured prefix and suffix
+func (b *backend) checkName(name string) bool {
+	return len(name) > len(b.prefix)+len(b.suffix) &&
+		strings.HasPrefix(name, b.prefix) &&
+		strings.HasSuffix(name, b.suffix)
+}
+
+// checkAnnotation checks if the correct annotation for secfs is set
+// if ignoreAnnotation is set to true, the annotation will not be checked
+func (b *backend) checkAnnotation(ks *corev1.Secret) bool {
+	if b.ignoreAnnotation {
+		return true
+	}
+
+	v, ok := ks.Annotations[AnnotationKey]
+
+	return ok && v == AnnotationValue
+}
+
+// helpers
+
+// merge decides if the change of s has to be applied to the key in ks (three-way merge)
+// base is the data s has been read with, theirs the current data of ks
+// returns false if ks already contains the change or s did not change the key
+func merge(ks *corev1.Secret, s Secret) (bool, error) {
+	ours, oursOk := s.Value(), !s.Delete()
+	theirs, theirsOk := ks.Data[s.Key()]
+
+	if equalValue(ours, oursOk, theirs, theirsOk) {
+		return false, nil
+	}
+
+	if s.ResourceVersion() != "" && s.ResourceVersion() != ks.ResourceVersion {
+		base, baseOk := s.Data()[s.Key()]
+
+		// key not changed by s
+		if equalValue(ours, oursOk, base, baseOk) {
+			return false, nil
+		}
+
+		// key changed by s and concurrently by someone else
+		if !equalValue(theirs, theirsOk, base, baseOk) {
+			return false, ErrConflict
+		}
+	}
+
+	return true, nil
+}
+
+// annotationPatch returns the JSON merge patch setting or removing (value nil) the annotations
+// rv is the precondition for the patch
+func annotationPatch(rv string, annotations map[string]interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": rv,
+			"annotations":     annotations,
+		},
+	})
+}
+
+// jsonAnnotation returns the value of a JSON annotation for m, nil (remove) if m is empty
+func jsonAnnotation[V any](m map[string]V) (interface{}, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// setAnnotation sets the JSON annotation key for m in annotations if m is not empty or the annotation has been set
+// before (set), an empty m removes the annotation
+func setAnnotation[V any](annotations map[string]interface{}, key string, m map[string]V, set bool) error {
+	if !set && len(m) == 0 {
+		return nil
+	}
+
+	v, err := jsonAnnotation(m)
+	if err != nil {
+		return err
+	}
+
+	annotations[key] = v
+
+	return nil
+}
+
+// dataPatch returns the JSON merge patch setting or removing (value nil) the keys of data and
+// setting the annotations, rv is the precondition for the patch
+func dataPatch(r resource, data map[string][]byte, rv string, annotations map[string]interface{}) ([]byte, error) {
+	p := map[string]interface{}{}
+
+	for key, value := range data {
+		for field, v := range r.dataPatch(key, value) {
+			m, ok := p[field].(map[string]interface{})
+			if !ok {
+				m = map[string]interface{}{}
+				p[field] = m
+			}
+
+			for k, x := range v.(map[string]interface{}) {
+				m[k] = x
+			}
+		}
+	}
+
+	metadata := map[string]interface{}{
+		"resourceVersion": rv,
+	}
+
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+
+	p["metadata"] = metadata
+
+	return json.Marshal(p)
+}
+
+func isImmutable(ks *corev1.Secret) bool {
+	return ks.Immutable != nil && *ks.Immutable
+}
+
+func equalValue(a []byte, aOk bool, b []byte, bOk bool) bool {
+	return aOk == bOk && bytes.Equal(a, b)
+}
+
+func setCurrentTime(s *corev1.Secret) {
+	s.Annotations[ModTimeKey] = currentTime()
+}
+
+// setCurrentTimes sets the modification time of ks and of all its keys to the current time
+func setCurrentTimes(s *corev1.Secret) {
+	now := currentTime()
+	mtimes := make(map[string]string, len(s.Data))
+
+	for key := range s.Data {
+		mtimes[key] = now
+	}
+
+	s.Annotations[ModTimeKey] = now
+
+	// a map of strings can always be marshaled
+	if v, _ := jsonAnnotation(mtimes); v != nil {
+		s.Annotations[ModTimesKey] = v.(string)
+	} else {
+		delete(s.Annotations, ModTimesKey)
+	}
+}
+
+func currentTime() string {
+	return formatTime(time.Now())
+}
+
+// formatTime formats t with nanoseconds, so writes within the same second can be told apart
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseTime parses a time formatted with formatTime or with seconds only (written by earlier versions)
+func parseTime(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Parse(time.RFC3339, v)
+	}
+
+	return t, nil
+}
+
+// modTimes returns the formatted modification times of the keys of ks
+func modTimes(ks *corev1.Secret) (map[string]string, error) {
+	mtimes := make(map[string]string)
+
+	v, ok := ks.Annotations[ModTimesKey]
+	if !ok {
+		return mtimes, nil
+	}
+
+	if err := json.Unmarshal([]byte(v), &mtimes); err != nil {
+		return nil, fmt.Errorf("%w: annotation %s of %s: %v", syscall.EIO, ModTimesKey, ks.Name, err)
+	}
+
+	return mtimes, nil
+}
+
+// getModTimes returns the modification times of the keys of ks, malformed times are left out
+func getModTimes(ks *corev1.Secret) map[string]time.Time {
+	mtimes, _ := modTimes(ks)
+	times := make(map[string]time.Time, len(mtimes))
+
+	for key, v := range mtimes {
+		t, err := parseTime(v)
+		if err == nil {
+			times[key] = t
+		}
+	}
+
+	return times
+}
+
+// getTime returns the modification time of s
+// If the annotation is missing or malformed the time of the last write recorded in the managed
+// fields is returned, the creation time if there is none.
+func getTime(s *corev1.Secret) time.Time {
+	if t, err := parseTime(s.Annotations[ModTimeKey]); err == nil {
+		return t
+	}
+
+	var t time.Time
+
+	for _, f := range s.ManagedFields {
+		if f.Time != nil && f.Time.After(t) {
+			t = f.Time.Time
+		}
+	}
+
+	if t.IsZero() {
+		return s.CreationTimestamp.Time
+	}
+
+	return t
+}
@@ -0,0 +1,189 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/postfinance/secfs/internal/fakeclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestInternalExternalName(t *testing.T) {
+	cs := fakeclient.New()
+	b := New(cs,
+		WithSecretPrefix("unit-"),
+		WithSecretSuffix("-test"),
+	)
+
+	externalName := "password"
+	internalName := "unit-password-test"
+
+	in := b.(*backend).internalName(externalName)
+	require.Equal(t, internalName, in)
+
+	en := b.(*backend).externalName(internalName)
+	require.Equal(t, externalName, en)
+}
+
+func TestMapError(t *testing.T) {
+	gr := corev1.Resource("secrets")
+
+	tests := []struct {
+		err   error
+		errno syscall.Errno
+		is    error
+	}{
+		{apierr.NewNotFound(gr, "secret"), syscall.ENOENT, fs.ErrNotExist},
+		{apierr.NewAlreadyExists(gr, "secret"), syscall.EEXIST, fs.ErrExist},
+		{apierr.NewForbidden(gr, "secret", errors.New("forbidden")), syscall.EACCES, fs.ErrPermission},
+		{apierr.NewUnauthorized("unauthorized"), syscall.EACCES, fs.ErrPermission},
+		{apierr.NewInvalid(corev1.SchemeGroupVersion.WithKind("Secret").GroupKind(), "secret", nil), syscall.EINVAL, syscall.EINVAL},
+		{apierr.NewBadRequest("bad request"), syscall.EINVAL, syscall.EINVAL},
+		{apierr.NewRequestEntityTooLargeError("too large"), syscall.EFBIG, syscall.EFBIG},
+		{apierr.NewConflict(gr, "secret", errors.New("conflict")), syscall.EAGAIN, syscall.EAGAIN},
+		{apierr.NewTooManyRequests("slow down", 1), syscall.EAGAIN, syscall.EAGAIN},
+		{apierr.NewServiceUnavailable("unavailable"), syscall.EAGAIN, syscall.EAGAIN},
+		{apierr.NewTimeoutError("timeout", 1), syscall.ETIMEDOUT, syscall.ETIMEDOUT},
+		{apierr.NewServerTimeout(gr, "get", 1), syscall.ETIMEDOUT, syscall.ETIMEDOUT},
+		{context.DeadlineExceeded, syscall.ETIMEDOUT, syscall.ETIMEDOUT},
+		{apierr.NewMethodNotSupported(gr, "patch"), syscall.ENOTSUP, errors.ErrUnsupported},
+		{apierr.NewInternalError(errors.New("internal")), syscall.EIO, syscall.EIO},
+	}
+
+	for _, tt := range tests {
+		err := mapError(tt.err)
+		require.ErrorIs(t, err, tt.errno, tt.err.Error())
+		require.ErrorIs(t, err, tt.is, tt.err.Error())
+		require.ErrorIs(t, err, tt.err, tt.err.Error())
+		require.Equal(t, tt.err.Error(), err.Error())
+
+		if _, ok := tt.err.(apierr.APIStatus); ok {
+			var status apierr.APIStatus
+			require.ErrorAs(t, err, &status)
+		}
+	}
+
+	require.NoError(t, mapError(nil))
+
+	unmapped := errors.New("unmapped")
+	require.Equal(t, unmapped, mapError(unmapped))
+}
+
+func TestCompanionName(t *testing.T) {
+	name := companionName("secret", historyKind)
+	require.Regexp(t, `^secret-history-[0-9a-f]{12}$`, name)
+	require.NotEqual(t, name, companionName("secret2", historyKind))
+	require.Regexp(t, `^secret-chunk-[0-9a-f]{12}-a-0$`, companionName("secret", chunkKind, "a", "0"))
+
+	// the owner is truncated after the dot, which is removed
+	long := strings.Repeat("a", 231) + "." + strings.Repeat("b", 21)
+	name = companionName(long, historyKind)
+	require.Len(t, name, maxNameLength-1)
+	require.True(t, strings.HasPrefix(name, strings.Repeat("a", 231)+"-history-"))
+}
+
+func TestCompanionOwner(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := New(cs, WithHistory(5, 0), WithIgnoreAnnotation()).(*backend)
+
+	// a secret with the name of the history which does not belong to the secret
+	_, err := cs.CoreV1().Secrets("default").Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      companionName("secret", historyKind),
+			Namespace: "default",
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	ks := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: "default",
+		},
+	}
+
+	_, err = b.companion(ctx, "default", "secret", historyKind, HistoryOfKey)
+	require.ErrorIs(t, err, syscall.EEXIST)
+	require.ErrorIs(t, b.record(ctx, ks), fs.ErrExist)
+	require.ErrorIs(t, b.deleteHistory(ctx, ks), fs.ErrExist)
+
+	_, err = cs.CoreV1().Secrets("default").Get(ctx, companionName("secret", historyKind), metav1.GetOptions{})
+	require.NoError(t, err)
+}
+
+type metadata struct {
+	namespace, secret string
+}
+
+func (m metadata) Namespace() string { return m.namespace }
+func (m metadata) Secret() string    { return m.secret }
+func (m metadata) Key() string       { return "" }
+
+func TestCacheKeepsNewerSecret(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs := fakeclient.New()
+	b := New(cs, WithCache(ctx, nil), WithIgnoreAnnotation()).(*backend)
+
+	_, err := cs.CoreV1().Secrets("default").Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"},
+		Data:       map[string][]byte{"key": []byte("v1")},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	cachedValue := func() string {
+		ks, ok, err := b.cache.get(ctx, "default", "secret")
+		if err != nil || !ok {
+			return ""
+		}
+
+		return string(ks.Data["key"])
+	}
+
+	require.Eventually(t, func() bool { return cachedValue() == "v1" }, time.Second, time.Millisecond)
+
+	// the secret is changed and the watch event is cached after the GET has read the old secret
+	gvr := corev1.SchemeGroupVersion.WithResource("secrets")
+
+	cs.PrependReactor("get", "secrets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := cs.Tracker().Get(gvr, "default", "secret")
+		if err != nil {
+			return true, nil, err
+		}
+
+		changed := obj.DeepCopyObject().(*corev1.Secret)
+		changed.ResourceVersion = "100"
+		changed.Data = map[string][]byte{"key": []byte("v2")}
+
+		if err := cs.Tracker().Update(gvr, changed, "default"); err != nil {
+			return true, nil, err
+		}
+
+		if !assert.Eventually(t, func() bool { return cachedValue() == "v2" }, time.Second, time.Millisecond) {
+			return true, nil, errors.New("watch event not cached")
+		}
+
+		cs.ReactionChain = cs.ReactionChain[1:]
+
+		return true, obj, nil
+	})
+
+	ks, err := b.get(ctx, metadata{namespace: "default", secret: "secret"})
+	require.NoError(t, err)
+	require.Equal(t, "v1", string(ks.Data["key"]))
+
+	require.Equal(t, "v2", cachedValue())
+}
@@ -0,0 +1,2055 @@
+package backend_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/postfinance/secfs/backend"
+	"github.com/postfinance/secfs/internal/fakeclient"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestBackend(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs,
+		backend.WithSecretPrefix(fakeclient.Prefix),
+		backend.WithSecretSuffix(fakeclient.Suffix),
+	)
+
+	t.Run("get secret not managed with secfs", func(t *testing.T) {
+		s, err := newFakeSecret("default", "notmanaged", "", []byte{})
+		require.NoError(t, err)
+
+		err = b.Get(ctx, s)
+		require.ErrorIs(t, err, backend.ErrNotManaged)
+	})
+
+	b = backend.New(cs,
+		backend.WithSecretPrefix(fakeclient.Prefix),
+		backend.WithSecretSuffix(fakeclient.Suffix),
+		backend.WithIgnoreAnnotation(),
+	)
+
+	t.Run("get secret not managed with secfs WithIgnoreAnnotation", func(t *testing.T) {
+		s, err := newFakeSecret("default", "notmanaged", "", []byte{})
+		require.NoError(t, err)
+
+		err = b.Get(ctx, s)
+		require.NoError(t, err)
+	})
+
+	t.Run("create get", func(t *testing.T) {
+		s, err := newFakeSecret("default", "secret", "", []byte{})
+		require.NoError(t, err)
+
+		data := map[string][]byte{
+			"key1": []byte("value1"),
+		}
+
+		s.SetData(data)
+
+		err = b.Create(ctx, s)
+		require.NoError(t, err)
+
+		s1, err := newFakeSecret("default", "secret", "", []byte{})
+		require.NoError(t, err)
+
+		err = b.Get(ctx, s1)
+		require.NoError(t, err)
+		require.Equal(t, data, s1.Data())
+		require.Equal(t, 1, len(s1.Data()))
+		require.Equal(t, []byte("value1"), s1.Data()["key1"])
+	})
+
+	t.Run("get update get", func(t *testing.T) {
+		s, err := newFakeSecret("default", "secret", "key2", []byte("value2"))
+		require.NoError(t, err)
+
+		err = b.Get(ctx, s)
+		require.NoError(t, err)
+
+		err = b.Update(ctx, s)
+		require.NoError(t, err)
+
+		s1, err := newFakeSecret("default", "secret", "key3", []byte("value3"))
+		require.NoError(t, err)
+
+		err = b.Get(ctx, s1)
+		require.NoError(t, err)
+
+		err = b.Update(ctx, s1)
+		require.NoError(t, err)
+
+		s2, err := newFakeSecret("default", "secret", "", []byte{})
+		require.NoError(t, err)
+
+		err = b.Get(ctx, s2)
+		require.NoError(t, err)
+
+		require.Equal(t, 3, len(s2.Data()))
+		require.Equal(t, []byte("value1"), s2.Data()["key1"])
+		require.Equal(t, []byte("value2"), s2.Data()["key2"])
+		require.Equal(t, []byte("value3"), s2.Data()["key3"])
+	})
+
+	t.Run("get update with delete get", func(t *testing.T) {
+		s, err := newFakeSecretDeleteKey("default", "secret", "key3")
+		require.NoError(t, err)
+
+		err = b.Get(ctx, s)
+		require.NoError(t, err)
+
+		err = b.Update(ctx, s)
+		require.NoError(t, err)
+
+		s1, err := newFakeSecret("default", "secret", "", []byte{})
+		require.NoError(t, err)
+
+		err = b.Get(ctx, s1)
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(s1.Data()))
+		require.Equal(t, []byte("value1"), s1.Data()["key1"])
+		require.Equal(t, []byte("value2"), s1.Data()["key2"])
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		// TODO: add tests
+		// rename old does not exist
+		// rename new does already exist
+
+		o, err := newFakeSecret("default", "secret-not-existing", "", []byte{})
+		require.NoError(t, err)
+
+		n, err := newFakeSecret("default", "secret-new", "", []byte{})
+		require.NoError(t, err)
+
+		err = b.Rename(ctx, o, n)
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		o, err = newFakeSecret("default", "secret", "", []byte{})
+		require.NoError(t, err)
+
+		n, err = newFakeSecret("default", "secret-existing", "", []byte{})
+		require.NoError(t, err)
+
+		err = b.Create(ctx, n)
+		require.NoError(t, err)
+
+		err = b.Rename(ctx, o, n)
+		require.ErrorIs(t, err, fs.ErrExist)
+
+		o, err = newFakeSecret("default", "secret", "", []byte{})
+		require.NoError(t, err)
+
+		n, err = newFakeSecret("default", "secret-new", "", []byte{})
+		require.NoError(t, err)
+
+		err = b.Rename(ctx, o, n)
+		require.NoError(t, err)
+
+		err = b.Get(ctx, n)
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(n.Data()))
+		require.Equal(t, []byte("value1"), n.Data()["key1"])
+		require.Equal(t, []byte("value2"), n.Data()["key2"])
+	})
+
+	t.Run("namespaces list", func(t *testing.T) {
+		ns, err := b.Namespaces(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"default"}, ns)
+
+		l, err := b.List(ctx, "default")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"notmanaged", "secret-existing", "secret-new"}, l)
+
+		_, err = b.List(ctx, "notexisting")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("delete get delete", func(t *testing.T) {
+		s, err := newFakeSecret("default", "secret-new", "", []byte{})
+		require.NoError(t, err)
+
+		err = b.Delete(ctx, s)
+		require.NoError(t, err)
+
+		err = b.Get(ctx, s)
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		err = b.Delete(ctx, s)
+		require.NoError(t, err)
+	})
+}
+
+type fakeSecret struct {
+	namespace string
+	secret    string
+
+	key   string
+	value []byte
+	data  map[string][]byte
+
+	delete bool
+
+	mtime time.Time
+	rv    string
+	stype corev1.SecretType
+	size  int
+
+	immutable bool
+	attrs     map[string]backend.Attributes
+	mtimes    map[string]time.Time
+}
+
+func newFakeSecret(ns, s, k string, v []byte) (backend.Secret, error) {
+	return &fakeSecret{
+		namespace: ns,
+		secret:    s,
+		key:       k,
+		value:     v,
+	}, nil
+}
+
+func newFakeSecretDeleteKey(ns, s, k string) (backend.Secret, error) {
+	return &fakeSecret{
+		namespace: ns,
+		secret:    s,
+		key:       k,
+		delete:    true,
+	}, nil
+}
+
+func (s *fakeSecret) Namespace() string {
+	return s.namespace
+}
+func (s *fakeSecret) Secret() string {
+	return s.secret
+}
+func (s *fakeSecret) Key() string {
+	return s.key
+}
+func (s *fakeSecret) Value() []byte {
+	return s.value
+}
+
+func (s *fakeSecret) Data() map[string][]byte {
+	return s.data
+}
+
+func (s *fakeSecret) SetData(data map[string][]byte) {
+	s.data = data
+}
+
+func (s *fakeSecret) SetTime(mtime time.Time) {
+	s.mtime = mtime
+}
+
+func (s *fakeSecret) Delete() bool {
+	return s.delete
+}
+
+func (s *fakeSecret) SetSize(size int) {
+	s.size = size
+}
+
+func (s *fakeSecret) SecretType() corev1.SecretType {
+	return s.stype
+}
+
+func (s *fakeSecret) SetSecretType(t corev1.SecretType) {
+	s.stype = t
+}
+
+func (s *fakeSecret) ResourceVersion() string {
+	return s.rv
+}
+
+func (s *fakeSecret) SetResourceVersion(rv string) {
+	s.rv = rv
+}
+
+func (s *fakeSecret) SetImmutable(immutable bool) {
+	s.immutable = immutable
+}
+
+func (s *fakeSecret) SetModTimes(mtimes map[string]time.Time) {
+	s.mtimes = mtimes
+}
+
+func (s *fakeSecret) SetAttributes(attrs map[string]backend.Attributes) {
+	s.attrs = attrs
+}
+
+func TestBackendConflict(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs)
+
+	s, err := newFakeSecret("default", "secret", "", []byte{})
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"key1": []byte("value1"),
+		"key2": []byte("value2"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	t.Run("concurrent update of different keys", func(t *testing.T) {
+		s1, err := newFakeSecret("default", "secret", "key1", []byte("value1a"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s1))
+
+		s2, err := newFakeSecret("default", "secret", "key2", []byte("value2a"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s2))
+
+		require.NoError(t, b.Update(ctx, s1))
+		require.NoError(t, b.Update(ctx, s2))
+
+		r, err := newFakeSecret("default", "secret", "", []byte{})
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, []byte("value1a"), r.Data()["key1"])
+		require.Equal(t, []byte("value2a"), r.Data()["key2"])
+		require.Equal(t, s2.ResourceVersion(), r.ResourceVersion())
+	})
+
+	t.Run("concurrent update of the same key", func(t *testing.T) {
+		s1, err := newFakeSecret("default", "secret", "key1", []byte("value1b"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s1))
+
+		s2, err := newFakeSecret("default", "secret", "key1", []byte("value1c"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s2))
+
+		require.NoError(t, b.Update(ctx, s1))
+		require.ErrorIs(t, b.Update(ctx, s2), backend.ErrConflict)
+
+		// same change as the concurrent update is not a conflict
+		s3, err := newFakeSecretDeleteKey("default", "secret", "key1")
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s3))
+
+		s4, err := newFakeSecretDeleteKey("default", "secret", "key1")
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s4))
+
+		require.NoError(t, b.Update(ctx, s3))
+		require.NoError(t, b.Update(ctx, s4))
+	})
+
+	t.Run("conflict reported by the API server is retried", func(t *testing.T) {
+		conflicts := 2
+
+		cs.PrependReactor("patch", "secrets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+			if conflicts == 0 {
+				return false, nil, nil
+			}
+
+			conflicts--
+
+			return true, nil, apierr.NewConflict(a.GetResource().GroupResource(), "secret", errors.New("conflict"))
+		})
+
+		s1, err := newFakeSecret("default", "secret", "key3", []byte("value3"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s1))
+		require.NoError(t, b.Update(ctx, s1))
+		require.Zero(t, conflicts)
+	})
+
+	t.Run("persistent conflict keeps the API error", func(t *testing.T) {
+		cs.PrependReactor("patch", "secrets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewConflict(a.GetResource().GroupResource(), "secret", errors.New("conflict"))
+		})
+
+		s1, err := newFakeSecret("default", "secret", "key4", []byte("value4"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s1))
+
+		err = b.Update(ctx, s1)
+		require.ErrorIs(t, err, backend.ErrConflict)
+		require.ErrorIs(t, err, syscall.EAGAIN)
+
+		var status *apierr.StatusError
+		require.ErrorAs(t, err, &status)
+		require.True(t, apierr.IsConflict(status))
+	})
+}
+
+func TestBackendPatch(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs)
+
+	s, err := newFakeSecret("default", "secret", "", []byte{})
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"key1": []byte("value1"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	t.Run("fields of other writers are preserved", func(t *testing.T) {
+		s1, err := newFakeSecret("default", "secret", "key2", []byte("value2"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s1))
+
+		// other writer
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+
+		ks.Labels = map[string]string{"other": "writer"}
+		ks.Data["key3"] = []byte("value3")
+
+		_, err = cs.CoreV1().Secrets("default").Update(ctx, ks, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		cs.ClearActions()
+
+		require.NoError(t, b.Update(ctx, s1))
+
+		for _, a := range cs.Actions() {
+			require.NotEqual(t, "update", a.GetVerb())
+		}
+
+		ks, err = cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"other": "writer"}, ks.Labels)
+		require.Equal(t, map[string][]byte{
+			"key1": []byte("value1"),
+			"key2": []byte("value2"),
+			"key3": []byte("value3"),
+		}, ks.Data)
+		require.Contains(t, ks.Annotations, backend.ModTimeKey)
+	})
+
+	t.Run("delete key with patch", func(t *testing.T) {
+		s1, err := newFakeSecretDeleteKey("default", "secret", "key1")
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s1))
+		require.NoError(t, b.Update(ctx, s1))
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotContains(t, ks.Data, "key1")
+		require.Len(t, ks.Data, 2)
+	})
+}
+
+func TestBackendConfigMap(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.NewConfigMap(cs,
+		backend.WithSecretPrefix(fakeclient.Prefix),
+		backend.WithSecretSuffix(fakeclient.Suffix),
+		backend.WithSecretLabels(map[string]string{"app": "secfs"}),
+	)
+
+	name := fakeclient.Prefix + "config" + fakeclient.Suffix
+	binary := []byte{0xff, 0xfe, 0x00}
+
+	s, err := newFakeSecret("default", "config", "", []byte{})
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"text": []byte("value"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	t.Run("create", func(t *testing.T) {
+		cm, err := cs.CoreV1().ConfigMaps("default").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"text": "value"}, cm.Data)
+		require.Equal(t, backend.AnnotationValue, cm.Annotations[backend.AnnotationKey])
+		require.Equal(t, map[string]string{"app": "secfs"}, cm.Labels)
+
+		_, err = cs.CoreV1().Secrets("default").Get(ctx, name, metav1.GetOptions{})
+		require.True(t, apierr.IsNotFound(err))
+	})
+
+	t.Run("update binary key", func(t *testing.T) {
+		s1, err := newFakeSecret("default", "config", "binary", binary)
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s1))
+		require.NoError(t, b.Update(ctx, s1))
+
+		cm, err := cs.CoreV1().ConfigMaps("default").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"text": "value"}, cm.Data)
+		require.Equal(t, map[string][]byte{"binary": binary}, cm.BinaryData)
+
+		s2, err := newFakeSecret("default", "config", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s2))
+		require.Equal(t, map[string][]byte{
+			"text":   []byte("value"),
+			"binary": binary,
+		}, s2.Data())
+	})
+
+	t.Run("key moves between data and binary data", func(t *testing.T) {
+		s1, err := newFakeSecret("default", "config", "text", binary)
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s1))
+		require.NoError(t, b.Update(ctx, s1))
+
+		cm, err := cs.CoreV1().ConfigMaps("default").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotContains(t, cm.Data, "text")
+		require.Equal(t, binary, cm.BinaryData["text"])
+	})
+
+	t.Run("delete key", func(t *testing.T) {
+		s1, err := newFakeSecretDeleteKey("default", "config", "binary")
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s1))
+		require.NoError(t, b.Update(ctx, s1))
+
+		cm, err := cs.CoreV1().ConfigMaps("default").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotContains(t, cm.BinaryData, "binary")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		names, err := b.List(ctx, "default")
+		require.NoError(t, err)
+		require.Equal(t, []string{"config"}, names)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		o, err := newFakeSecret("default", "config", "", nil)
+		require.NoError(t, err)
+		n, err := newFakeSecret("default", "renamed", "", nil)
+		require.NoError(t, err)
+
+		require.NoError(t, b.Rename(ctx, o, n))
+		require.NoError(t, b.Get(ctx, n))
+		require.Equal(t, binary, n.Data()["text"])
+		require.ErrorIs(t, b.Get(ctx, o), fs.ErrNotExist)
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		cb := backend.NewConfigMap(cs,
+			backend.WithSecretPrefix(fakeclient.Prefix),
+			backend.WithSecretSuffix(fakeclient.Suffix),
+			backend.WithCache(ctx, nil, "default"),
+		)
+
+		cs.ClearActions()
+
+		r, err := newFakeSecret("default", "renamed", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, cb.Get(ctx, r))
+		require.Equal(t, binary, r.Data()["text"])
+
+		for _, a := range cs.Actions() {
+			require.NotEqual(t, "get", a.GetVerb(), a.GetResource().Resource)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s1, err := newFakeSecret("default", "renamed", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.Delete(ctx, s1))
+
+		l, err := cs.CoreV1().ConfigMaps("default").List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, l.Items)
+	})
+}
+
+func TestBackendSecretType(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithSecretType(corev1.SecretTypeTLS))
+
+	t.Run("create without required keys", func(t *testing.T) {
+		s, err := newFakeSecret("default", "tls", "", nil)
+		require.NoError(t, err)
+
+		s.SetData(map[string][]byte{
+			corev1.TLSCertKey: []byte("cert"),
+		})
+
+		err = b.Create(ctx, s)
+		require.ErrorIs(t, err, backend.ErrRequiredKey)
+		require.ErrorContains(t, err, corev1.TLSPrivateKeyKey)
+
+		_, err = cs.CoreV1().Secrets("default").Get(ctx, "tls", metav1.GetOptions{})
+		require.True(t, apierr.IsNotFound(err))
+	})
+
+	t.Run("create with configured type", func(t *testing.T) {
+		s, err := newFakeSecret("default", "tls", "", nil)
+		require.NoError(t, err)
+
+		s.SetData(map[string][]byte{
+			corev1.TLSCertKey:       []byte("cert"),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+		})
+
+		require.NoError(t, b.Create(ctx, s))
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "tls", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, corev1.SecretTypeTLS, ks.Type)
+
+		r, err := newFakeSecret("default", "tls", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, corev1.SecretTypeTLS, r.SecretType())
+	})
+
+	t.Run("create with type of secret", func(t *testing.T) {
+		s, err := newFakeSecret("default", "auth", "", nil)
+		require.NoError(t, err)
+
+		s.SetSecretType(corev1.SecretTypeBasicAuth)
+		s.SetData(map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte("user"),
+			corev1.BasicAuthPasswordKey: []byte("password"),
+		})
+
+		require.NoError(t, b.Create(ctx, s))
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "auth", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, corev1.SecretTypeBasicAuth, ks.Type)
+	})
+
+	t.Run("update required key", func(t *testing.T) {
+		s, err := newFakeSecret("default", "tls", corev1.TLSPrivateKeyKey, []byte("new key"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s))
+		require.NoError(t, b.Update(ctx, s))
+	})
+
+	t.Run("delete required key", func(t *testing.T) {
+		s, err := newFakeSecretDeleteKey("default", "tls", corev1.TLSPrivateKeyKey)
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, s))
+		require.ErrorIs(t, b.Update(ctx, s), backend.ErrRequiredKey)
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "tls", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []byte("new key"), ks.Data[corev1.TLSPrivateKeyKey])
+	})
+
+	t.Run("type is preserved on rename", func(t *testing.T) {
+		o, err := newFakeSecret("default", "tls", "", nil)
+		require.NoError(t, err)
+		n, err := newFakeSecret("default", "renamed", "", nil)
+		require.NoError(t, err)
+
+		require.NoError(t, b.Rename(ctx, o, n))
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "renamed", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, corev1.SecretTypeTLS, ks.Type)
+	})
+}
+
+func TestBackendSize(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs)
+
+	t.Run("create too large", func(t *testing.T) {
+		s, err := newFakeSecret("default", "large", "", nil)
+		require.NoError(t, err)
+
+		s.SetData(map[string][]byte{
+			"key": make([]byte, backend.MaxSecretSize),
+		})
+
+		require.ErrorIs(t, b.Create(ctx, s), syscall.EFBIG)
+	})
+
+	s, err := newFakeSecret("default", "secret", "", nil)
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"key1": make([]byte, backend.MaxSecretSize/2),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	t.Run("size is set", func(t *testing.T) {
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.NoError(t, b.Get(ctx, r))
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, backend.EncodedSize(ks), r.size)
+		require.Greater(t, r.size, backend.MaxSecretSize/2*4/3)
+	})
+
+	t.Run("update too large", func(t *testing.T) {
+		u, err := newFakeSecret("default", "secret", "key2", make([]byte, backend.MaxSecretSize/4))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+
+		cs.ClearActions()
+
+		require.ErrorIs(t, b.Update(ctx, u), syscall.EFBIG)
+
+		for _, a := range cs.Actions() {
+			require.NotEqual(t, "patch", a.GetVerb())
+		}
+	})
+
+	t.Run("update replacing a key", func(t *testing.T) {
+		u, err := newFakeSecret("default", "secret", "key1", make([]byte, backend.MaxSecretSize/2+1024))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+		require.NoError(t, b.Update(ctx, u))
+	})
+}
+
+func TestBackendChunking(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithChunking(), backend.WithIgnoreAnnotation())
+
+	large := make([]byte, 2*backend.ChunkSize+100)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+
+	// chunks returns the names of the chunk secrets in the namespace default
+	chunks := func() []string {
+		l, err := cs.CoreV1().Secrets("default").List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+
+		names := []string{}
+
+		for i := range l.Items {
+			if _, ok := l.Items[i].Annotations[backend.ChunkOfKey]; ok {
+				names = append(names, l.Items[i].Name)
+			}
+		}
+
+		return names
+	}
+
+	t.Run("create", func(t *testing.T) {
+		s, err := newFakeSecret("default", "secret", "", nil)
+		require.NoError(t, err)
+
+		s.SetData(map[string][]byte{
+			"large": large,
+			"small": []byte("small"),
+		})
+
+		require.NoError(t, b.Create(ctx, s))
+		require.Len(t, chunks(), 3)
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotContains(t, ks.Data, "large")
+		require.Equal(t, []byte("small"), ks.Data["small"])
+		require.Contains(t, ks.Annotations, backend.ChunksKey)
+
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, large, r.Data()["large"])
+		require.Equal(t, -1, r.size)
+	})
+
+	t.Run("list hides chunks", func(t *testing.T) {
+		names, err := b.List(ctx, "default")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"secret", fakeclient.Prefix + "notmanaged" + fakeclient.Suffix}, names)
+	})
+
+	t.Run("update replaces chunks", func(t *testing.T) {
+		old := chunks()
+
+		u, err := newFakeSecret("default", "secret", "large", large[:backend.ChunkSize*3/2])
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+		require.NoError(t, b.Update(ctx, u))
+		require.Equal(t, large[:backend.ChunkSize*3/2], u.Data()["large"])
+
+		current := chunks()
+		require.Len(t, current, 2)
+
+		for _, name := range old {
+			require.NotContains(t, current, name)
+		}
+	})
+
+	t.Run("update with small value is stored in the secret", func(t *testing.T) {
+		u, err := newFakeSecret("default", "secret", "large", []byte("not large anymore"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+		require.NoError(t, b.Update(ctx, u))
+		require.Empty(t, chunks())
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []byte("not large anymore"), ks.Data["large"])
+		require.NotContains(t, ks.Annotations, backend.ChunksKey)
+	})
+
+	t.Run("failed update removes the new chunks", func(t *testing.T) {
+		u, err := newFakeSecret("default", "secret", "large", large)
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+
+		fail := true
+
+		cs.PrependReactor("patch", "secrets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+			if !fail {
+				return false, nil, nil
+			}
+
+			return true, nil, apierr.NewForbidden(a.GetResource().GroupResource(), "secret", errors.New("forbidden"))
+		})
+
+		require.ErrorIs(t, b.Update(ctx, u), fs.ErrPermission)
+		require.Empty(t, chunks())
+
+		fail = false
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []byte("not large anymore"), ks.Data["large"])
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		u, err := newFakeSecret("default", "secret", "large", large)
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+		require.NoError(t, b.Update(ctx, u))
+
+		old := chunks()
+		require.Len(t, old, 3)
+
+		o, err := newFakeSecret("default", "secret", "", nil)
+		require.NoError(t, err)
+		n, err := newFakeSecret("default", "renamed", "", nil)
+		require.NoError(t, err)
+
+		require.NoError(t, b.Rename(ctx, o, n))
+		require.NoError(t, b.Get(ctx, n))
+		require.Equal(t, large, n.Data()["large"])
+
+		current := chunks()
+		require.Len(t, current, 3)
+
+		for _, name := range old {
+			require.NotContains(t, current, name)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s, err := newFakeSecret("default", "renamed", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.Delete(ctx, s))
+		require.Empty(t, chunks())
+	})
+
+	t.Run("without chunking", func(t *testing.T) {
+		s, err := newFakeSecret("default", "secret", "", nil)
+		require.NoError(t, err)
+
+		s.SetData(map[string][]byte{
+			"large": large,
+		})
+
+		require.ErrorIs(t, backend.New(cs).Create(ctx, s), syscall.EFBIG)
+	})
+}
+
+func TestBackendRetry(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithRetry(3, time.Millisecond))
+
+	s, err := newFakeSecret("default", "secret", "", []byte{})
+	require.NoError(t, err)
+	require.NoError(t, b.Create(ctx, s))
+
+	// failures injects n times err on verb for secrets and counts the calls
+	failures := func(verb string, n int, err error) *int {
+		calls := 0
+
+		cs.PrependReactor(verb, "secrets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+			calls++
+
+			if calls > n {
+				return false, nil, nil
+			}
+
+			return true, nil, err
+		})
+
+		return &calls
+	}
+
+	unavailable := apierr.NewServiceUnavailable("unavailable")
+
+	t.Run("transient error is retried", func(t *testing.T) {
+		calls := failures("get", 2, unavailable)
+
+		require.NoError(t, b.Get(ctx, s))
+		require.Equal(t, 3, *calls)
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		calls := failures("get", 3, unavailable)
+
+		err := b.Get(ctx, s)
+		require.True(t, apierr.IsServiceUnavailable(err))
+		require.Equal(t, 3, *calls)
+
+		*calls = 3 // disable
+	})
+
+	t.Run("error not transient", func(t *testing.T) {
+		calls := failures("get", 1, apierr.NewForbidden(corev1.Resource("secrets"), "secret", errors.New("forbidden")))
+
+		err := b.Get(ctx, s)
+		require.True(t, apierr.IsForbidden(err))
+		require.Equal(t, 1, *calls)
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		calls := failures("patch", 1, apierr.NewTooManyRequests("slow down", 1))
+
+		u, err := newFakeSecret("default", "secret", "key1", []byte("value1"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+
+		start := time.Now()
+
+		require.NoError(t, b.Update(ctx, u))
+		require.Equal(t, 2, *calls)
+		require.GreaterOrEqual(t, time.Since(start), time.Second)
+	})
+
+	t.Run("canceled context stops retries", func(t *testing.T) {
+		b := backend.New(cs, backend.WithRetry(3, time.Hour))
+		calls := failures("delete", 1, unavailable)
+
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		err := b.Delete(ctx, s)
+		require.True(t, apierr.IsServiceUnavailable(err))
+		require.Equal(t, 1, *calls)
+	})
+}
+
+func TestBackendCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs := fakeclient.New()
+	b := backend.New(cs,
+		backend.WithCache(ctx, nil, "default"),
+	)
+
+	s, err := newFakeSecret("default", "secret", "", []byte{})
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"key1": []byte("value1"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	// secretGets counts the requests to get secrets from the API server
+	secretGets := func() int {
+		n := 0
+
+		for _, a := range cs.Actions() {
+			if a.GetVerb() == "get" && a.GetResource().Resource == "secrets" {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	t.Run("read from cache", func(t *testing.T) {
+		cs.ClearActions()
+
+		r, err := newFakeSecret("default", "secret", "", []byte{})
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, []byte("value1"), r.Data()["key1"])
+
+		l, err := b.List(ctx, "default")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"secret"}, l)
+
+		require.Zero(t, secretGets())
+	})
+
+	t.Run("read after write", func(t *testing.T) {
+		u, err := newFakeSecret("default", "secret", "key2", []byte("value2"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+		require.NoError(t, b.Update(ctx, u))
+
+		r, err := newFakeSecret("default", "secret", "", []byte{})
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, []byte("value2"), r.Data()["key2"])
+		require.Equal(t, u.ResourceVersion(), r.ResourceVersion())
+	})
+
+	t.Run("updated from watch events", func(t *testing.T) {
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+
+		ks.Data["key3"] = []byte("value3")
+
+		_, err = cs.CoreV1().Secrets("default").Update(ctx, ks, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			r, err := newFakeSecret("default", "secret", "", []byte{})
+			require.NoError(t, err)
+			require.NoError(t, b.Get(ctx, r))
+
+			return string(r.Data()["key3"]) == "value3"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("not cached secret is read from the API server", func(t *testing.T) {
+		r, err := newFakeSecret("default", "notexisting", "", []byte{})
+		require.NoError(t, err)
+		require.ErrorIs(t, b.Get(ctx, r), fs.ErrNotExist)
+
+		r, err = newFakeSecret("scratch", "secret", "", []byte{})
+		require.NoError(t, err)
+		require.ErrorIs(t, b.Get(ctx, r), fs.ErrNotExist)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, b.Delete(ctx, s))
+
+		r, err := newFakeSecret("default", "secret", "", []byte{})
+		require.NoError(t, err)
+		require.ErrorIs(t, b.Get(ctx, r), fs.ErrNotExist)
+
+		l, err := b.List(ctx, "default")
+		require.NoError(t, err)
+		require.Empty(t, l)
+	})
+}
+
+func TestBackendEncryption(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+
+	keys := backend.StaticKeys{
+		CurrentID: "1",
+		Keys: map[string][]byte{
+			"1": []byte("0123456789abcdef"),
+		},
+	}
+	b := backend.New(cs, backend.WithEncryption(keys), backend.WithIgnoreAnnotation())
+
+	raw := func(name string) *corev1.Secret {
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+
+		return ks
+	}
+
+	t.Run("create encrypts all values", func(t *testing.T) {
+		s, err := newFakeSecret("default", "secret", "", nil)
+		require.NoError(t, err)
+
+		s.SetData(map[string][]byte{
+			"key1": []byte("value1"),
+			"key2": []byte("value2"),
+		})
+
+		require.NoError(t, b.Create(ctx, s))
+
+		ks := raw("secret")
+		require.NotEqual(t, []byte("value1"), ks.Data["key1"])
+		require.JSONEq(t, `{"key1":{"alg":"AES-GCM","kid":"1"},"key2":{"alg":"AES-GCM","kid":"1"}}`, ks.Annotations[backend.EncryptionKey])
+
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, []byte("value1"), r.Data()["key1"])
+		require.Equal(t, []byte("value2"), r.Data()["key2"])
+	})
+
+	t.Run("update encrypts the value", func(t *testing.T) {
+		u, err := newFakeSecret("default", "secret", "key1", []byte("updated"))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+		require.NoError(t, b.Update(ctx, u))
+		require.Equal(t, []byte("updated"), u.Data()["key1"])
+		require.NotContains(t, string(raw("secret").Data["key1"]), "updated")
+	})
+
+	t.Run("delete removes the encryption", func(t *testing.T) {
+		d, err := newFakeSecretDeleteKey("default", "secret", "key2")
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, d))
+		require.NoError(t, b.Update(ctx, d))
+		require.JSONEq(t, `{"key1":{"alg":"AES-GCM","kid":"1"}}`, raw("secret").Annotations[backend.EncryptionKey])
+	})
+
+	t.Run("without keys", func(t *testing.T) {
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.ErrorIs(t, backend.New(cs, backend.WithIgnoreAnnotation()).Get(ctx, r), fs.ErrPermission)
+	})
+
+	t.Run("value moved to another key", func(t *testing.T) {
+		ks := raw("secret")
+		ks.Data["key3"] = ks.Data["key1"]
+		ks.Annotations[backend.EncryptionKey] = `{"key1":{"alg":"AES-GCM","kid":"1"},"key3":{"alg":"AES-GCM","kid":"1"}}`
+
+		_, err := cs.CoreV1().Secrets("default").Update(ctx, ks, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.ErrorIs(t, b.Get(ctx, r), syscall.EIO)
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		ks := raw("secret")
+		delete(ks.Data, "key3")
+		ks.Data["plain"] = []byte("plain")
+		ks.Annotations[backend.EncryptionKey] = `{"key1":{"alg":"AES-GCM","kid":"1"}}`
+
+		_, err := cs.CoreV1().Secrets("default").Update(ctx, ks, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		rotated := backend.StaticKeys{
+			CurrentID: "2",
+			Keys: map[string][]byte{
+				"1": keys.Keys["1"],
+				"2": []byte("fedcba9876543210fedcba9876543210"),
+			},
+		}
+		b := backend.New(cs, backend.WithEncryption(rotated), backend.WithIgnoreAnnotation())
+
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, []byte("updated"), r.Data()["key1"])
+		require.Equal(t, []byte("plain"), r.Data()["plain"])
+
+		modTimes := raw("secret").Annotations[backend.ModTimesKey]
+		modTime := raw("secret").Annotations[backend.ModTimeKey]
+
+		m, err := newFakeSecret("default", "secret", "key1", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.(backend.Reencrypter).Reencrypt(ctx, m))
+		require.JSONEq(t, `{"key1":{"alg":"AES-GCM","kid":"2"}}`, raw("secret").Annotations[backend.EncryptionKey])
+
+		m, err = newFakeSecret("default", "secret", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.(backend.Reencrypter).Reencrypt(ctx, m))
+		require.JSONEq(t, `{"key1":{"alg":"AES-GCM","kid":"2"},"plain":{"alg":"AES-GCM","kid":"2"}}`, raw("secret").Annotations[backend.EncryptionKey])
+
+		// re-encryption does not modify the keys
+		require.Equal(t, modTimes, raw("secret").Annotations[backend.ModTimesKey])
+		require.Equal(t, modTime, raw("secret").Annotations[backend.ModTimeKey])
+
+		r = &fakeSecret{namespace: "default", secret: "secret"}
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, map[string][]byte{"key1": []byte("updated"), "plain": []byte("plain")}, r.Data())
+
+		m, err = newFakeSecret("default", "secret", "missing", nil)
+		require.NoError(t, err)
+		require.ErrorIs(t, b.(backend.Reencrypter).Reencrypt(ctx, m), fs.ErrNotExist)
+	})
+
+	t.Run("unknown key ID", func(t *testing.T) {
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.ErrorIs(t, b.Get(ctx, r), syscall.EACCES)
+	})
+
+	t.Run("chunks are encrypted", func(t *testing.T) {
+		b := backend.New(cs, backend.WithEncryption(keys), backend.WithChunking(), backend.WithIgnoreAnnotation())
+
+		large := make([]byte, 2*backend.ChunkSize)
+		for i := range large {
+			large[i] = byte(i % 251)
+		}
+
+		s, err := newFakeSecret("default", "large", "", nil)
+		require.NoError(t, err)
+
+		s.SetData(map[string][]byte{
+			"large": large,
+		})
+
+		require.NoError(t, b.Create(ctx, s))
+		require.Contains(t, raw("large").Annotations, backend.ChunksKey)
+
+		r := &fakeSecret{namespace: "default", secret: "large"}
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, large, r.Data()["large"])
+	})
+
+	t.Run("keys validated by the API server", func(t *testing.T) {
+		s := &fakeSecret{
+			namespace: "default",
+			secret:    "registry",
+			stype:     corev1.SecretTypeDockerConfigJson,
+			data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte("{}"),
+			},
+		}
+		require.ErrorIs(t, b.Create(ctx, s), syscall.EINVAL)
+
+		_, err := cs.CoreV1().Secrets("default").Get(ctx, "registry", metav1.GetOptions{})
+		require.True(t, apierr.IsNotFound(err))
+
+		_, err = cs.CoreV1().Secrets("default").Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte("{}"),
+				"note":                     []byte("note"),
+			},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+
+		u, err := newFakeSecret("default", "registry", corev1.DockerConfigJsonKey, []byte(`{"auths":{}}`))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+		require.ErrorIs(t, b.Update(ctx, u), syscall.EINVAL)
+
+		m, err := newFakeSecret("default", "registry", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.(backend.Reencrypter).Reencrypt(ctx, m))
+
+		ks := raw("registry")
+		require.Equal(t, []byte("{}"), ks.Data[corev1.DockerConfigJsonKey])
+		require.JSONEq(t, `{"note":{"alg":"AES-GCM","kid":"1"}}`, ks.Annotations[backend.EncryptionKey])
+	})
+}
+
+func TestBackendImmutable(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithImmutable(), backend.WithIgnoreAnnotation())
+
+	s, err := newFakeSecret("default", "secret", "", nil)
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"key1": []byte("value1"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	t.Run("created immutable", func(t *testing.T) {
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, ks.Immutable)
+		require.True(t, *ks.Immutable)
+
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.NoError(t, b.Get(ctx, r))
+		require.True(t, r.immutable)
+	})
+
+	t.Run("update fails", func(t *testing.T) {
+		u, err := newFakeSecret("default", "secret", "key1", []byte("updated"))
+		require.NoError(t, err)
+		require.ErrorIs(t, b.Update(ctx, u), syscall.EPERM)
+
+		d, err := newFakeSecretDeleteKey("default", "secret", "key1")
+		require.NoError(t, err)
+		require.ErrorIs(t, b.Update(ctx, d), fs.ErrPermission)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		r.SetData(map[string][]byte{
+			"key2": []byte("value2"),
+		})
+
+		require.NoError(t, b.(backend.Replacer).Replace(ctx, r))
+		require.Equal(t, map[string][]byte{"key2": []byte("value2")}, r.Data())
+		require.True(t, r.immutable)
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.True(t, *ks.Immutable)
+		require.Equal(t, map[string][]byte{"key2": []byte("value2")}, ks.Data)
+	})
+
+	// failCreate fails the next n creates of the secret name
+	failCreate := func(name string, n int) {
+		cs.PrependReactor("create", "secrets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+			if n == 0 || a.(k8stesting.CreateAction).GetObject().(*corev1.Secret).Name != name {
+				return false, nil, nil
+			}
+
+			n--
+
+			return true, nil, apierr.NewForbidden(a.GetResource().GroupResource(), name, errors.New("forbidden"))
+		})
+	}
+
+	// name of the backup of secret
+	var backupName string
+
+	t.Run("replace restores the secret if create fails", func(t *testing.T) {
+		failCreate("secret", 1)
+
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		r.SetData(map[string][]byte{
+			"key3": []byte("value3"),
+		})
+
+		require.ErrorIs(t, b.(backend.Replacer).Replace(ctx, r), fs.ErrPermission)
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.True(t, *ks.Immutable)
+		require.Equal(t, map[string][]byte{"key2": []byte("value2")}, ks.Data)
+	})
+
+	t.Run("replace keeps a backup if restore fails", func(t *testing.T) {
+		failCreate("secret", 2)
+
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		r.SetData(map[string][]byte{
+			"key3": []byte("value3"),
+		})
+
+		err := b.(backend.Replacer).Replace(ctx, r)
+		require.ErrorIs(t, err, fs.ErrPermission)
+		require.ErrorContains(t, err, "restore secret")
+
+		_, err = cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.True(t, apierr.IsNotFound(err))
+
+		backups := companions(t, cs, backend.BackupOfKey, "secret")
+		require.Len(t, backups, 1)
+
+		backup := &backups[0]
+		backupName = backup.Name
+		require.Equal(t, map[string][]byte{"key2": []byte("value2")}, backup.Data)
+
+		names, err := b.List(ctx, "default")
+		require.NoError(t, err)
+		require.NotContains(t, names, backupName)
+
+		// the backup is restored manually
+		backup.Name = "secret"
+		backup.ResourceVersion = ""
+		delete(backup.Annotations, backend.BackupOfKey)
+
+		_, err = cs.CoreV1().Secrets("default").Create(ctx, backup, metav1.CreateOptions{})
+		require.NoError(t, err)
+
+		// replace fails as long as the backup exists
+		require.ErrorIs(t, b.(backend.Replacer).Replace(ctx, r), fs.ErrExist)
+
+		require.NoError(t, cs.CoreV1().Secrets("default").Delete(ctx, backupName, metav1.DeleteOptions{}))
+		require.NoError(t, b.(backend.Replacer).Replace(ctx, r))
+		require.Empty(t, companions(t, cs, backend.BackupOfKey, "secret"))
+	})
+
+	t.Run("foreign secrets are not backups", func(t *testing.T) {
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		r.SetData(map[string][]byte{
+			"key4": []byte("value4"),
+		})
+
+		// a secret of the user named like a backup does not interfere
+		_, err := cs.CoreV1().Secrets("default").Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "secret-backup", Namespace: "default"},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, b.(backend.Replacer).Replace(ctx, r))
+
+		// a secret with the name of the backup which is not a backup of the secret is never used as such
+		_, err = cs.CoreV1().Secrets("default").Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: backupName, Namespace: "default"},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+
+		err = b.(backend.Replacer).Replace(ctx, r)
+		require.ErrorIs(t, err, fs.ErrExist)
+		require.ErrorContains(t, err, "does not belong to secret")
+
+		require.ErrorIs(t, b.Delete(ctx, r), fs.ErrExist)
+		require.NoError(t, cs.CoreV1().Secrets("default").Delete(ctx, backupName, metav1.DeleteOptions{}))
+
+		_, err = cs.CoreV1().Secrets("default").Get(ctx, "secret-backup", metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("rename and delete handle a backup", func(t *testing.T) {
+		s, err := newFakeSecret("default", "kept", "", nil)
+		require.NoError(t, err)
+
+		s.SetData(map[string][]byte{"key": []byte("value")})
+		require.NoError(t, b.Create(ctx, s))
+
+		failCreate("kept", 2)
+
+		r := &fakeSecret{namespace: "default", secret: "kept"}
+		r.SetData(map[string][]byte{"key": []byte("new")})
+		require.Error(t, b.(backend.Replacer).Replace(ctx, r))
+		require.Len(t, companions(t, cs, backend.BackupOfKey, "kept"), 1)
+
+		// the secret is restored manually, the backup is left
+		require.NoError(t, b.Create(ctx, s))
+
+		n, err := newFakeSecret("default", "moved", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.Rename(ctx, s, n))
+		require.Empty(t, companions(t, cs, backend.BackupOfKey, "kept"))
+		require.Len(t, companions(t, cs, backend.BackupOfKey, "moved"), 1)
+
+		require.NoError(t, b.Delete(ctx, n))
+		require.Empty(t, companions(t, cs, backend.BackupOfKey, "moved"))
+	})
+
+	t.Run("replace missing secret", func(t *testing.T) {
+		r := &fakeSecret{namespace: "default", secret: "missing"}
+		require.ErrorIs(t, b.(backend.Replacer).Replace(ctx, r), fs.ErrNotExist)
+	})
+}
+
+func TestBackendHistory(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithHistory(2, 0), backend.WithIgnoreAnnotation())
+	h := b.(backend.Historian)
+
+	s, err := newFakeSecret("default", "secret", "", nil)
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"key1": []byte("v1"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	update := func(key, value string) {
+		u, err := newFakeSecret("default", "secret", key, []byte(value))
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+		require.NoError(t, b.Update(ctx, u))
+	}
+
+	t.Run("no revisions", func(t *testing.T) {
+		revisions, err := h.Revisions(ctx, s)
+		require.NoError(t, err)
+		require.Empty(t, revisions)
+	})
+
+	t.Run("update records the previous data", func(t *testing.T) {
+		update("key1", "v2")
+		update("key2", "v1")
+
+		revisions, err := h.Revisions(ctx, s)
+		require.NoError(t, err)
+		require.Len(t, revisions, 2)
+		require.Equal(t, 1, revisions[0].ID)
+		require.Equal(t, 2, revisions[1].ID)
+
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.NoError(t, h.GetRevision(ctx, r, 1))
+		require.Equal(t, map[string][]byte{"key1": []byte("v1")}, r.Data())
+
+		require.NoError(t, h.GetRevision(ctx, r, 2))
+		require.Equal(t, map[string][]byte{"key1": []byte("v2")}, r.Data())
+
+		require.NoError(t, h.GetRevision(ctx, r, backend.CurrentRevision))
+		require.Equal(t, map[string][]byte{"key1": []byte("v2"), "key2": []byte("v1")}, r.Data())
+
+		require.ErrorIs(t, h.GetRevision(ctx, r, 3), fs.ErrNotExist)
+	})
+
+	t.Run("revisions of a key", func(t *testing.T) {
+		k, err := newFakeSecret("default", "secret", "key2", nil)
+		require.NoError(t, err)
+
+		revisions, err := h.Revisions(ctx, k)
+		require.NoError(t, err)
+		require.Empty(t, revisions)
+	})
+
+	t.Run("oldest revisions are removed", func(t *testing.T) {
+		update("key2", "v2")
+
+		revisions, err := h.Revisions(ctx, s)
+		require.NoError(t, err)
+		require.Len(t, revisions, 2)
+		require.Equal(t, 2, revisions[0].ID)
+		require.Equal(t, 3, revisions[1].ID)
+	})
+
+	t.Run("history is hidden", func(t *testing.T) {
+		histories := companions(t, cs, backend.HistoryOfKey, "secret")
+		require.Len(t, histories, 1)
+
+		names, err := b.List(ctx, "default")
+		require.NoError(t, err)
+		require.NotContains(t, names, histories[0].Name)
+	})
+
+	t.Run("rename moves the history", func(t *testing.T) {
+		n, err := newFakeSecret("default", "renamed", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.Rename(ctx, s, n))
+
+		revisions, err := h.Revisions(ctx, n)
+		require.NoError(t, err)
+		require.Len(t, revisions, 2)
+
+		require.Empty(t, companions(t, cs, backend.HistoryOfKey, "secret"))
+		require.Len(t, companions(t, cs, backend.HistoryOfKey, "renamed"), 1)
+	})
+
+	t.Run("delete removes the history", func(t *testing.T) {
+		n, err := newFakeSecret("default", "renamed", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.Delete(ctx, n))
+
+		require.Empty(t, companions(t, cs, backend.HistoryOfKey, "renamed"))
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		changes := backend.Diff(map[string][]byte{
+			"a": []byte("1"),
+			"b": []byte("2"),
+		}, map[string][]byte{
+			"b": []byte("3"),
+			"c": []byte("4"),
+		})
+		require.Equal(t, []backend.Change{
+			{Key: "a", Type: backend.Removed},
+			{Key: "b", Type: backend.Modified},
+			{Key: "c", Type: backend.Added},
+		}, changes)
+	})
+}
+
+func TestBackendHistoryForeignSecret(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithHistory(5, 0), backend.WithIgnoreAnnotation())
+
+	// a secret of the user named like a history secret
+	foreign := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret-history",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{"key": []byte("value")},
+	}
+
+	_, err := cs.CoreV1().Secrets("default").Create(ctx, foreign, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	s, err := newFakeSecret("default", "secret", "", nil)
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{"key": []byte("v1")})
+	require.NoError(t, b.Create(ctx, s))
+
+	u, err := newFakeSecret("default", "secret", "key", []byte("v2"))
+	require.NoError(t, err)
+	require.NoError(t, b.Get(ctx, u))
+	require.NoError(t, b.Update(ctx, u))
+	require.NoError(t, b.Delete(ctx, s))
+
+	ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret-history", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, foreign.Data, ks.Data)
+}
+
+// companions returns the secrets in the namespace default referring to owner with annotation
+func companions(t *testing.T, cs kubernetes.Interface, annotation, owner string) []corev1.Secret {
+	t.Helper()
+
+	l, err := cs.CoreV1().Secrets("default").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+
+	secrets := []corev1.Secret{}
+
+	for _, ks := range l.Items {
+		if ks.Annotations[annotation] == owner {
+			secrets = append(secrets, ks)
+		}
+	}
+
+	return secrets
+}
+
+func TestBackendHistoryTooLarge(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithHistory(5, 4096), backend.WithIgnoreAnnotation())
+	h := b.(backend.Historian)
+
+	s, err := newFakeSecret("default", "secret", "", nil)
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"key": []byte("v1"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	update := func(value []byte) {
+		u, err := newFakeSecret("default", "secret", "key", value)
+		require.NoError(t, err)
+		require.NoError(t, b.Get(ctx, u))
+		require.NoError(t, b.Update(ctx, u))
+	}
+
+	update([]byte("v2"))
+	update(make([]byte, 5000))
+
+	revisions, err := h.Revisions(ctx, s)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+
+	// the large value does not fit into the history, the previous revisions are kept
+	update([]byte("v3"))
+
+	revisions, err = h.Revisions(ctx, s)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	require.Equal(t, 1, revisions[0].ID)
+	require.Equal(t, 2, revisions[1].ID)
+}
+
+func TestBackendWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithIgnoreAnnotation(), backend.WithRetry(1, time.Millisecond))
+
+	s, err := newFakeSecret("default", "secret", "", nil)
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"key1": []byte("v1"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	// the watches are controlled by the test, rvs are the resourceVersions they are started with
+	var (
+		watches = make(chan *watch.RaceFreeFakeWatcher, 10)
+		rvs     = make(chan string, 10)
+	)
+
+	cs.PrependWatchReactor("secrets", func(a k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewRaceFreeFake()
+		rvs <- a.(k8stesting.WatchActionImpl).WatchRestrictions.ResourceVersion
+		watches <- w
+
+		return true, w, nil
+	})
+
+	events, err := b.(backend.Watcher).Watch(ctx, s)
+	require.NoError(t, err)
+
+	update := func(key, value string) *corev1.Secret {
+		u, err := newFakeSecret("default", "secret", key, []byte(value))
+		require.NoError(t, err)
+		require.NoError(t, b.Update(ctx, u))
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+
+		return ks
+	}
+
+	w := <-watches
+	require.Equal(t, "", <-rvs)
+
+	t.Run("modified", func(t *testing.T) {
+		w.Modify(update("key2", "v2"))
+
+		e := <-events
+		require.NoError(t, e.Err)
+		require.Equal(t, "default", e.Namespace)
+		require.Equal(t, "secret", e.Secret)
+		require.Equal(t, map[string][]byte{"key1": []byte("v1")}, e.Old)
+		require.Equal(t, map[string][]byte{"key1": []byte("v1"), "key2": []byte("v2")}, e.New)
+	})
+
+	t.Run("other secrets are ignored", func(t *testing.T) {
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+
+		w.Add(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", ResourceVersion: ks.ResourceVersion}})
+		w.Modify(update("key2", "v3"))
+
+		e := <-events
+		require.Equal(t, []byte("v3"), e.New["key2"])
+	})
+
+	t.Run("resumed with the last resourceVersion", func(t *testing.T) {
+		ks := update("key2", "v4")
+		w.Modify(ks)
+		<-events
+
+		w.Stop()
+
+		w = <-watches
+		require.Equal(t, ks.ResourceVersion, <-rvs)
+
+		w.Modify(update("key1", "v5"))
+
+		e := <-events
+		require.Equal(t, []byte("v5"), e.New["key1"])
+	})
+
+	t.Run("relisted if the resourceVersion has expired", func(t *testing.T) {
+		update("key1", "v6")
+
+		w.Error(&apierr.NewResourceExpired("too old").ErrStatus)
+
+		e := <-events
+		require.NoError(t, e.Err)
+		require.Equal(t, []byte("v5"), e.Old["key1"])
+		require.Equal(t, []byte("v6"), e.New["key1"])
+
+		w = <-watches
+		<-rvs
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+
+		w.Delete(ks)
+
+		e := <-events
+		require.NotNil(t, e.Old)
+		require.Nil(t, e.New)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		cancel()
+
+		for range events {
+		}
+	})
+}
+
+func TestBackendAttributes(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithIgnoreAnnotation())
+
+	s, err := newFakeSecret("default", "secret", "", nil)
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"key1": []byte("value1"),
+		"key2": []byte("value2"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	at, ok := b.(backend.Attributer)
+	require.True(t, ok)
+
+	mode := fs.FileMode(0o400)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	m := &fakeSecret{namespace: "default", secret: "secret", key: "key1"}
+	require.NoError(t, at.SetAttributes(ctx, m, backend.Attributes{Mode: &mode}))
+	require.NoError(t, at.SetAttributes(ctx, m, backend.Attributes{Mtime: &mtime}))
+
+	t.Run("stored", func(t *testing.T) {
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"key1":{"mode":256}}`, ks.Annotations[backend.AttributesKey])
+
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, mode, *r.attrs["key1"].Mode)
+		require.True(t, mtime.Equal(r.mtimes["key1"]))
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		m := &fakeSecret{namespace: "default", secret: "secret", key: "missing"}
+		require.ErrorIs(t, at.SetAttributes(ctx, m, backend.Attributes{Mode: &mode}), syscall.ENOENT)
+	})
+
+	t.Run("write resets mtime", func(t *testing.T) {
+		u, err := newFakeSecret("default", "secret", "key1", []byte("updated"))
+		require.NoError(t, err)
+		require.NoError(t, b.Update(ctx, u))
+
+		require.Equal(t, mode, *u.(*fakeSecret).attrs["key1"].Mode)
+		require.False(t, mtime.Equal(u.(*fakeSecret).mtimes["key1"]))
+	})
+
+	t.Run("delete removes attributes", func(t *testing.T) {
+		d, err := newFakeSecretDeleteKey("default", "secret", "key1")
+		require.NoError(t, err)
+		require.NoError(t, b.Update(ctx, d))
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotContains(t, ks.Annotations, backend.AttributesKey)
+	})
+}
+
+func TestBackendAttributesChunked(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithChunking(), backend.WithIgnoreAnnotation())
+
+	s, err := newFakeSecret("default", "big", "", nil)
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"k": make([]byte, 2*1024*1024),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	ks, err := cs.CoreV1().Secrets("default").Get(ctx, "big", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NotContains(t, ks.Data, "k")
+
+	mode := fs.FileMode(0o400)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	m := &fakeSecret{namespace: "default", secret: "big", key: "k"}
+	require.NoError(t, b.(backend.Attributer).SetAttributes(ctx, m, backend.Attributes{Mode: &mode, Mtime: &mtime}))
+
+	r := &fakeSecret{namespace: "default", secret: "big"}
+	require.NoError(t, b.Get(ctx, r))
+	require.Equal(t, mode, *r.attrs["k"].Mode)
+	require.True(t, mtime.Equal(r.mtimes["k"]))
+}
+
+func TestBackendModTimes(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs, backend.WithIgnoreAnnotation())
+
+	s, err := newFakeSecret("default", "secret", "", nil)
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"key1": []byte("value1"),
+		"key2": []byte("value2"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	// getModTimes reads the modification times of the keys from the annotation
+	getModTimes := func(t *testing.T) map[string]string {
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+
+		mtimes := map[string]string{}
+		require.NoError(t, json.Unmarshal([]byte(ks.Annotations[backend.ModTimesKey]), &mtimes))
+
+		return mtimes
+	}
+
+	t.Run("created", func(t *testing.T) {
+		mtimes := getModTimes(t)
+		require.Len(t, mtimes, 2)
+		require.Equal(t, mtimes["key1"], mtimes["key2"])
+	})
+
+	// the annotation of a key written before is replaced
+	past := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, b.(backend.Attributer).SetAttributes(ctx, &fakeSecret{namespace: "default", secret: "secret", key: "key2"}, backend.Attributes{Mtime: &past}))
+
+	t.Run("update", func(t *testing.T) {
+		u, err := newFakeSecret("default", "secret", "key1", []byte("updated"))
+		require.NoError(t, err)
+		require.NoError(t, b.Update(ctx, u))
+
+		mtimes := u.(*fakeSecret).mtimes
+		require.True(t, past.Equal(mtimes["key2"]))
+		require.True(t, mtimes["key1"].After(past))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		d, err := newFakeSecretDeleteKey("default", "secret", "key1")
+		require.NoError(t, err)
+		require.NoError(t, b.Update(ctx, d))
+
+		mtimes := getModTimes(t)
+		require.NotContains(t, mtimes, "key1")
+		require.Contains(t, mtimes, "")
+		require.Equal(t, "2020-01-02T03:04:05Z", mtimes["key2"])
+	})
+}
+
+func TestBackendModTimePrecision(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	b := backend.New(cs)
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	written := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		annotation    string
+		managedFields []metav1.ManagedFieldsEntry
+		expected      time.Time
+	}{
+		"nanoseconds": {
+			annotation: "2022-01-02T03:04:05.123456789Z",
+			expected:   time.Date(2022, 1, 2, 3, 4, 5, 123456789, time.UTC),
+		},
+		"seconds": {
+			annotation: "2022-01-02T03:04:05Z",
+			expected:   time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		"malformed": {
+			annotation: "yesterday",
+			managedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl", Time: &metav1.Time{Time: created}},
+				{Manager: "other", Time: &metav1.Time{Time: written}},
+			},
+			expected: written,
+		},
+		"missing": {
+			expected: created,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ks := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              name,
+					Namespace:         "default",
+					CreationTimestamp: metav1.Time{Time: created},
+					ManagedFields:     tc.managedFields,
+					Annotations: map[string]string{
+						backend.AnnotationKey: backend.AnnotationValue,
+					},
+				},
+			}
+
+			if tc.annotation != "" {
+				ks.Annotations[backend.ModTimeKey] = tc.annotation
+			}
+
+			_, err := cs.CoreV1().Secrets("default").Create(ctx, ks, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			s := &fakeSecret{namespace: "default", secret: name}
+			require.NoError(t, b.Get(ctx, s))
+			require.True(t, tc.expected.Equal(s.mtime), s.mtime)
+		})
+	}
+
+	t.Run("writes within a second", func(t *testing.T) {
+		s, err := newFakeSecret("default", "secret", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, b.Create(ctx, s))
+
+		u1, err := newFakeSecret("default", "secret", "key", []byte("value1"))
+		require.NoError(t, err)
+		require.NoError(t, b.Update(ctx, u1))
+
+		u2, err := newFakeSecret("default", "secret", "key", []byte("value2"))
+		require.NoError(t, err)
+		require.NoError(t, b.Update(ctx, u2))
+
+		require.True(t, u2.(*fakeSecret).mtimes["key"].After(u1.(*fakeSecret).mtimes["key"]))
+	})
+}
+
+func TestBackendRenameKey(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	keys := backend.StaticKeys{
+		CurrentID: "1",
+		Keys:      map[string][]byte{"1": make([]byte, 32)},
+	}
+	b := backend.New(cs, backend.WithEncryption(keys), backend.WithIgnoreAnnotation())
+	kr := b.(backend.KeyRenamer)
+
+	s, err := newFakeSecret("default", "secret", "", nil)
+	require.NoError(t, err)
+
+	s.SetData(map[string][]byte{
+		"old": []byte("value"),
+	})
+
+	require.NoError(t, b.Create(ctx, s))
+
+	mode := fs.FileMode(0o400)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	o := &fakeSecret{namespace: "default", secret: "secret", key: "old"}
+	n := &fakeSecret{namespace: "default", secret: "secret", key: "new"}
+
+	require.NoError(t, b.(backend.Attributer).SetAttributes(ctx, o, backend.Attributes{Mode: &mode, Mtime: &mtime}))
+
+	t.Run("rename", func(t *testing.T) {
+		cs.ClearActions()
+
+		require.NoError(t, kr.RenameKey(ctx, o, n))
+
+		patches := 0
+
+		for _, a := range cs.Actions() {
+			if a.GetVerb() == "patch" {
+				patches++
+			}
+		}
+
+		require.Equal(t, 1, patches)
+
+		// the value is encrypted for the new key name
+		r := &fakeSecret{namespace: "default", secret: "secret"}
+		require.NoError(t, b.Get(ctx, r))
+		require.Equal(t, map[string][]byte{"new": []byte("value")}, r.Data())
+		require.Equal(t, mode, *r.attrs["new"].Mode)
+		require.True(t, mtime.Equal(r.mtimes["new"]))
+		require.NotContains(t, r.attrs, "old")
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		require.ErrorIs(t, kr.RenameKey(ctx, o, n), fs.ErrNotExist)
+	})
+
+	t.Run("other secret", func(t *testing.T) {
+		other := &fakeSecret{namespace: "default", secret: "other", key: "new"}
+		require.ErrorIs(t, kr.RenameKey(ctx, n, other), syscall.EINVAL)
+	})
+}
@@ -0,0 +1,171 @@
+package backend
+
+import (
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// secretCache serves reads of secrets from shared informers
+// The informers are updated from watch events, the results of writes are stored
+// immediately so reads after writes do not return outdated secrets.
+// Resources other than secrets are cached as they are and converted on access.
+type secretCache struct {
+	informers map[string]cache.SharedIndexInformer // by namespace, metav1.NamespaceAll for all namespaces
+	selector  labels.Selector
+	set       labels.Set // labels of selector
+	r         resource
+}
+
+// newSecretCache starts the informers for namespaces (all namespaces if empty)
+// with the label selector, the informers run until ctx is done
+func newSecretCache(ctx context.Context, c kubernetes.Interface, r resource, selector map[string]string, namespaces []string) *secretCache {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	sc := &secretCache{
+		informers: make(map[string]cache.SharedIndexInformer, len(namespaces)),
+		selector:  labels.SelectorFromSet(selector),
+		set:       labels.Set(selector),
+		r:         r,
+	}
+
+	for _, ns := range namespaces {
+		f := informers.NewSharedInformerFactoryWithOptions(c, 0,
+			informers.WithNamespace(ns),
+			informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+				o.LabelSelector = sc.selector.String()
+			}),
+		)
+
+		sc.informers[ns] = r.informer(f)
+
+		f.Start(ctx.Done())
+	}
+
+	return sc
+}
+
+// informer returns the synced informer for namespace, false if namespace is not cached
+func (sc *secretCache) informer(ctx context.Context, namespace string) (cache.SharedIndexInformer, bool, error) {
+	i, ok := sc.cached(namespace)
+	if !ok {
+		return nil, false, nil
+	}
+
+	if !i.HasSynced() && !cache.WaitForCacheSync(ctx.Done(), i.HasSynced) {
+		return nil, false, ctx.Err()
+	}
+
+	return i, true, nil
+}
+
+// get returns a copy of the cached secret, false if it is not in the cache
+func (sc *secretCache) get(ctx context.Context, namespace, name string) (*corev1.Secret, bool, error) {
+	i, ok, err := sc.informer(ctx, namespace)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	obj, ok, err := i.GetIndexer().GetByKey(namespace + "/" + name)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	return sc.r.toSecret(obj), true, nil
+}
+
+// list returns copies of the cached secrets in namespace, false if namespace is not cached
+func (sc *secretCache) list(ctx context.Context, namespace string, selector labels.Selector) ([]corev1.Secret, bool, error) {
+	i, ok, err := sc.informer(ctx, namespace)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	secrets := []corev1.Secret{}
+
+	err = cache.ListAllByNamespace(i.GetIndexer(), namespace, selector, func(obj interface{}) {
+		secrets = append(secrets, *sc.r.toSecret(obj))
+	})
+
+	return secrets, true, err
+}
+
+// covers checks if all secrets with labels are cached, i.e. labels include the labels of the selector
+// Listings of secrets with labels which are not covered can not be served from the cache.
+func (sc *secretCache) covers(l map[string]string) bool {
+	for k, v := range sc.set {
+		if x, ok := l[k]; !ok || x != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// update stores the result of a read or write in the cache, watch events update it later on again
+// A secret which has been cached with a newer resourceVersion in between, e.g. from a watch event
+// received after the secret has been read, is not overwritten.
+func (sc *secretCache) update(ks *corev1.Secret) {
+	if !sc.selector.Matches(labels.Set(ks.Labels)) {
+		return
+	}
+
+	i, ok := sc.cached(ks.Namespace)
+	if !ok {
+		return
+	}
+
+	obj, ok, err := i.GetIndexer().GetByKey(ks.Namespace + "/" + ks.Name)
+	if err == nil && ok && newer(sc.r.toSecret(obj).ResourceVersion, ks.ResourceVersion) {
+		return
+	}
+
+	_ = i.GetIndexer().Update(sc.r.fromSecret(ks))
+}
+
+// newer checks if the resourceVersion a is newer than b
+// resourceVersions are opaque, the API server backed by etcd uses increasing integers. Others are never newer.
+func newer(a, b string) bool {
+	x, err := strconv.ParseUint(a, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	y, err := strconv.ParseUint(b, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return x > y
+}
+
+// delete removes a deleted secret from the cache
+func (sc *secretCache) delete(namespace, name string) {
+	if i, ok := sc.cached(namespace); ok {
+		_ = i.GetIndexer().Delete(sc.r.fromSecret(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+			},
+		}))
+	}
+}
+
+// cached returns the informer responsible for namespace
+func (sc *secretCache) cached(namespace string) (cache.SharedIndexInformer, bool) {
+	i, ok := sc.informers[namespace]
+	if !ok {
+		i, ok = sc.informers[metav1.NamespaceAll]
+	}
+
+	return i, ok
+}
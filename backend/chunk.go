@@ -0,0 +1,226 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ChunksKey is the name of the annotation listing the chunk secrets of the keys stored in chunks
+	// The value is a JSON object with the keys and the names of their chunk secrets in order.
+	ChunksKey = "chunks"
+	// ChunkOfKey is the name of the annotation of a chunk secret referring to the secret it belongs to
+	ChunkOfKey = "chunk-of"
+	// ChunkSize is the maximum length of the part of a value stored in one chunk secret
+	ChunkSize = 512 * 1024
+
+	chunkDataKey = "chunk"
+)
+
+// chunkRefs returns the names of the chunk secrets by key
+func chunkRefs(ks *corev1.Secret) (map[string][]string, error) {
+	refs := make(map[string][]string)
+
+	v, ok := ks.Annotations[ChunksKey]
+	if !ok {
+		return refs, nil
+	}
+
+	if err := json.Unmarshal([]byte(v), &refs); err != nil {
+		return nil, fmt.Errorf("%w: annotation %s of %s: %v", syscall.EIO, ChunksKey, ks.Name, err)
+	}
+
+	return refs, nil
+}
+
+// stitch returns a copy of ks with the values of the keys stored in chunks
+func (b *backend) stitch(ctx context.Context, ks *corev1.Secret) (*corev1.Secret, error) {
+	refs, err := chunkRefs(ks)
+	if err != nil || len(refs) == 0 {
+		return ks, err
+	}
+
+	ks = ks.DeepCopy()
+
+	for key, names := range refs {
+		var value bytes.Buffer
+
+		for _, name := range names {
+			c, err := b.getChunk(ctx, ks.Namespace, name)
+			if err != nil {
+				return nil, fmt.Errorf("%w: chunk %s of key %s: %v", syscall.EIO, name, key, err)
+			}
+
+			value.Write(c.Data[chunkDataKey])
+		}
+
+		ks.Data[key] = value.Bytes()
+	}
+
+	return ks, nil
+}
+
+// split moves the largest keys of ks into chunk secrets until ks fits into MaxSecretSize
+// returns the names of the created chunk secrets
+func (b *backend) split(ctx context.Context, ks *corev1.Secret) ([]string, error) {
+	refs, err := chunkRefs(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	if ks.Annotations == nil {
+		ks.Annotations = make(map[string]string)
+	}
+
+	created := []string{}
+
+	for b.size(ks) > MaxSecretSize {
+		key := largestKey(ks.Data)
+		if key == "" {
+			break
+		}
+
+		names, err := b.writeChunks(ctx, ks.Namespace, ks.Name, key, ks.Data[key])
+		if err != nil {
+			_ = b.deleteChunks(ctx, ks.Namespace, created)
+			return nil, err
+		}
+
+		created = append(created, names...)
+		refs[key] = names
+		delete(ks.Data, key)
+
+		v, err := jsonAnnotation(refs)
+		if err != nil {
+			_ = b.deleteChunks(ctx, ks.Namespace, created)
+			return nil, err
+		}
+
+		ks.Annotations[ChunksKey] = v.(string)
+	}
+
+	return created, nil
+}
+
+// writeChunks creates the chunk secrets for value of key in secret owner
+// The names are unique for every write, so the chunks of the previous value stay
+// intact until the owner refers to the new ones.
+func (b *backend) writeChunks(ctx context.Context, namespace, owner, key string, value []byte) ([]string, error) {
+	gen := make([]byte, 4)
+	if _, err := rand.Read(gen); err != nil {
+		return nil, err
+	}
+
+	h := sha256.Sum256([]byte(key))
+	names := []string{}
+
+	for i := 0; i == 0 || i*ChunkSize < len(value); i++ {
+		end := (i + 1) * ChunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+
+		c := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      companionName(owner, chunkKind, hex.EncodeToString(h[:4]), hex.EncodeToString(gen), strconv.Itoa(i)),
+				Namespace: namespace,
+				Labels:    b.labels,
+				Annotations: map[string]string{
+					ChunkOfKey: owner,
+				},
+			},
+			Data: map[string][]byte{
+				chunkDataKey: value[i*ChunkSize : end],
+			},
+		}
+
+		err := b.request(ctx, func(ctx context.Context) error {
+			c, err := b.r.create(ctx, c)
+			if err == nil {
+				b.cached(c)
+			}
+
+			return err
+		})
+		if err != nil {
+			_ = b.deleteChunks(ctx, namespace, names)
+			return nil, err
+		}
+
+		names = append(names, c.Name)
+	}
+
+	return names, nil
+}
+
+// deleteChunks removes the chunk secrets, chunks which do not exist (anymore) are not an error
+func (b *backend) deleteChunks(ctx context.Context, namespace string, names []string) error {
+	for _, name := range names {
+		err := b.retry(ctx, func(ctx context.Context) error {
+			return b.r.delete(ctx, namespace, name, metav1.DeleteOptions{})
+		})
+
+		if b.cache != nil && (err == nil || apierr.IsNotFound(err)) {
+			b.cache.delete(namespace, name)
+		}
+
+		if err != nil && !apierr.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getChunk returns the chunk secret from the cache if configured, from the API server otherwise
+func (b *backend) getChunk(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	if b.cache != nil {
+		c, ok, err := b.cache.get(ctx, namespace, name)
+		if ok || err != nil {
+			return c, err
+		}
+	}
+
+	var c *corev1.Secret
+
+	err := b.retry(ctx, func(ctx context.Context) error {
+		var err error
+		c, err = b.r.get(ctx, namespace, name)
+		return err
+	})
+
+	return c, err
+}
+
+// largestKey returns the key with the largest value, empty if data is empty
+func largestKey(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	largest := ""
+
+	for _, k := range keys {
+		if largest == "" || len(data[k]) > len(data[largest]) {
+			largest = k
+		}
+	}
+
+	return largest
+}
@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxNameLength is the maximum length of the name of a secret
+const maxNameLength = 253
+
+// kinds of the companion secrets stored next to a secret
+const (
+	historyKind = "history"
+	backupKind  = "backup"
+	chunkKind   = "chunk"
+)
+
+// companionName returns the name of the companion secret of kind belonging to owner, parts are appended
+// The name contains a hash of owner, so it does not collide with the secrets users name after their secrets
+// (e.g. <owner>-history). Long owner names are truncated to keep the name within maxNameLength.
+func companionName(owner, kind string, parts ...string) string {
+	h := sha256.Sum256([]byte(owner))
+	suffix := "-" + kind + "-" + hex.EncodeToString(h[:6])
+
+	for _, p := range parts {
+		suffix += "-" + p
+	}
+
+	if len(owner)+len(suffix) > maxNameLength {
+		// the name must not contain a label ending with - or .
+		owner = strings.TrimRight(owner[:maxNameLength-len(suffix)], "-.")
+	}
+
+	return owner + suffix
+}
+
+// companion returns the companion secret of kind of owner, nil if it does not exist
+// A secret with the name of the companion which does not refer to owner with annotation belongs to someone
+// else, it is never read, changed or removed: EEXIST is returned.
+func (b *backend) companion(ctx context.Context, namespace, owner, kind, annotation string) (*corev1.Secret, error) {
+	var c *corev1.Secret
+
+	err := b.retry(ctx, func(ctx context.Context) error {
+		var err error
+		c, err = b.r.get(ctx, namespace, companionName(owner, kind))
+		return err
+	})
+
+	if apierr.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Annotations[annotation] != owner {
+		return nil, fmt.Errorf("%w: secret %s does not belong to %s", syscall.EEXIST, c.Name, owner)
+	}
+
+	if c.Data == nil {
+		c.Data = make(map[string][]byte)
+	}
+
+	return c, nil
+}
+
+// renameCompanion moves the companion secret of kind of o to n
+func (b *backend) renameCompanion(ctx context.Context, o, n *corev1.Secret, kind, annotation string) error {
+	c, err := b.companion(ctx, o.Namespace, o.Name, kind, annotation)
+	if err != nil || c == nil {
+		return err
+	}
+
+	moved := recreated(c)
+	moved.Name = companionName(n.Name, kind)
+	moved.Namespace = n.Namespace
+	moved.Annotations[annotation] = n.Name
+
+	err = b.request(ctx, func(ctx context.Context) error {
+		_, err := b.r.create(ctx, moved)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.deleteCompanion(ctx, c)
+}
+
+// deleteCompanion removes the companion secret c conditional on its UID, so a secret created with the
+// name in between is not removed. A companion which does not exist (anymore) is not an error.
+func (b *backend) deleteCompanion(ctx context.Context, c *corev1.Secret) error {
+	opts := metav1.DeleteOptions{}
+	if c.UID != "" {
+		opts.Preconditions = &metav1.Preconditions{
+			UID: &c.UID,
+		}
+	}
+
+	err := b.retry(ctx, func(ctx context.Context) error {
+		return b.r.delete(ctx, c.Namespace, c.Name, opts)
+	})
+
+	if apierr.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,311 @@
+package backend
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"syscall"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// EncryptionKey is the name of the annotation with the algorithm and key ID of the encrypted keys
+	// The value is a JSON object with the keys and their encryption, e.g. {"key":{"alg":"AES-GCM","kid":"1"}}.
+	EncryptionKey = "encryption"
+	// EncryptionAlgorithm is the algorithm values are encrypted with
+	// The stored value is the nonce followed by the sealed value, the name of the key is authenticated.
+	EncryptionAlgorithm = "AES-GCM"
+)
+
+// ErrUnknownKeyID for values encrypted with a key the KeyProvider does not know
+var ErrUnknownKeyID = errors.New("unknown encryption key ID")
+
+// KeyProvider provides the AES keys (16, 24 or 32 bytes) for the encryption of values
+type KeyProvider interface {
+	// Current returns the ID and the key new values are encrypted with
+	Current() (id string, key []byte, err error)
+	// Key returns the key with id for the decryption of values, ErrUnknownKeyID if id is unknown
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider with fixed keys by ID
+// Values are encrypted with the key CurrentID, all keys can be used for decryption.
+type StaticKeys struct {
+	CurrentID string
+	Keys      map[string][]byte
+}
+
+var _ KeyProvider = StaticKeys{}
+
+// Current returns the key CurrentID (KeyProvider)
+func (k StaticKeys) Current() (string, []byte, error) {
+	key, err := k.Key(k.CurrentID)
+
+	return k.CurrentID, key, err
+}
+
+// Key returns the key with id (KeyProvider)
+func (k StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKeyID, id)
+	}
+
+	return key, nil
+}
+
+// Reencrypter is implemented by backends which can re-encrypt the values with the current key
+type Reencrypter interface {
+	// Reencrypt encrypts the key of m, all keys of the secret if the key is empty, with the current key
+	Reencrypt(ctx context.Context, m Metadata) error
+}
+
+var _ Reencrypter = (*backend)(nil)
+
+// encryption describes how the value of a key is encrypted
+type encryption struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// encryptions returns the encryption of the encrypted keys of ks
+func encryptions(ks *corev1.Secret) (map[string]encryption, error) {
+	enc := make(map[string]encryption)
+
+	v, ok := ks.Annotations[EncryptionKey]
+	if !ok {
+		return enc, nil
+	}
+
+	if err := json.Unmarshal([]byte(v), &enc); err != nil {
+		return nil, fmt.Errorf("%w: annotation %s of %s: %v", syscall.EIO, EncryptionKey, ks.Name, err)
+	}
+
+	return enc, nil
+}
+
+// encrypt returns the encrypted value of key
+func (b *backend) encrypt(t corev1.SecretType, key string, value []byte) ([]byte, encryption, error) {
+	if validatedKey(t, key) {
+		return nil, encryption{}, fmt.Errorf("%w: key %s of type %s is validated by the API server and can not be encrypted",
+			syscall.EINVAL, key, t)
+	}
+
+	id, k, err := b.keys.Current()
+	if err != nil {
+		return nil, encryption{}, err
+	}
+
+	aead, err := newAEAD(k)
+	if err != nil {
+		return nil, encryption{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, encryption{}, err
+	}
+
+	return aead.Seal(nonce, nonce, value, []byte(key)), encryption{
+		Algorithm: EncryptionAlgorithm,
+		KeyID:     id,
+	}, nil
+}
+
+// decrypt returns the decrypted value of key
+func (b *backend) decrypt(key string, value []byte, enc encryption) ([]byte, error) {
+	if b.keys == nil {
+		return nil, fmt.Errorf("%w: key %s is encrypted", syscall.EACCES, key)
+	}
+
+	if enc.Algorithm != EncryptionAlgorithm {
+		return nil, fmt.Errorf("%w: key %s is encrypted with %s", syscall.ENOTSUP, key, enc.Algorithm)
+	}
+
+	k, err := b.keys.Key(enc.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %s: %v", syscall.EACCES, key, err)
+	}
+
+	aead, err := newAEAD(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: key %s: encrypted value too short", syscall.EIO, key)
+	}
+
+	v, err := aead.Open(nil, value[:aead.NonceSize()], value[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %s: %v", syscall.EIO, key, err)
+	}
+
+	return v, nil
+}
+
+// decode returns a copy of ks with the values of the keys stored in chunks and decrypted values
+func (b *backend) decode(ctx context.Context, ks *corev1.Secret) (*corev1.Secret, error) {
+	data, err := b.stitch(ctx, ks)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := encryptions(ks)
+	if err != nil || len(enc) == 0 {
+		return data, err
+	}
+
+	data = data.DeepCopy()
+
+	for key, e := range enc {
+		v, ok := data.Data[key]
+		if !ok {
+			continue
+		}
+
+		data.Data[key], err = b.decrypt(key, v, e)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// encryptAll encrypts all values of ks with the current key
+func (b *backend) encryptAll(ks *corev1.Secret) error {
+	if b.keys == nil || len(ks.Data) == 0 {
+		return nil
+	}
+
+	enc := make(map[string]encryption, len(ks.Data))
+	data := make(map[string][]byte, len(ks.Data))
+
+	for key, v := range ks.Data {
+		var err error
+
+		data[key], enc[key], err = b.encrypt(ks.Type, key, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	v, err := jsonAnnotation(enc)
+	if err != nil {
+		return err
+	}
+
+	ks.Data = data
+	ks.Annotations[EncryptionKey] = v.(string)
+
+	return nil
+}
+
+// Reencrypt encrypts the key of m, all keys of the secret if the key is empty, with the current key (Reencrypter)
+// Keys which are not encrypted yet are encrypted, without encryption configured nothing is done.
+// Immutable secrets with outdated keys are rejected with EPERM, they have to be replaced (Replacer).
+// The modification times of the secret and its keys are kept, the values do not change.
+func (b *backend) Reencrypt(ctx context.Context, m Metadata) error {
+	if b.keys == nil {
+		return nil
+	}
+
+	id, _, err := b.keys.Current()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ks, err := b.get(ctx, m)
+		if err != nil {
+			return err
+		}
+
+		for {
+			data, err := b.decode(ctx, ks)
+			if err != nil {
+				return err
+			}
+
+			if _, ok := data.Data[m.Key()]; m.Key() != "" && !ok {
+				return syscall.ENOENT
+			}
+
+			enc, err := encryptions(ks)
+			if err != nil {
+				return err
+			}
+
+			key := outdatedKey(ks.Type, data.Data, enc, id, m.Key())
+			if key == "" {
+				return nil
+			}
+
+			if isImmutable(ks) {
+				return syscall.EPERM
+			}
+
+			value := data.Data[key]
+			if value == nil {
+				value = []byte{}
+			}
+
+			// the plaintext does not change, the key keeps its modification time
+			ks, err = b.patch(ctx, ks, keyChange{key: key, value: value, keep: true})
+			if err != nil {
+				return err
+			}
+		}
+	})
+
+	if apierr.IsConflict(err) {
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	}
+
+	return err
+}
+
+// outdatedKey returns the first key of data not encrypted with the key id, only key if it is not empty
+// Keys validated by the API server for type t are never encrypted and skipped.
+func outdatedKey(t corev1.SecretType, data map[string][]byte, enc map[string]encryption, id, key string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if (key != "" && k != key) || validatedKey(t, k) {
+			continue
+		}
+
+		if e, ok := enc[k]; !ok || e.KeyID != id || e.Algorithm != EncryptionAlgorithm {
+			return k
+		}
+	}
+
+	return ""
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(c)
+}
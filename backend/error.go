@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/net/context"
+
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// apiError is an error of a Kubernetes API request mapped to the corresponding syscall.Errno
+// errors.Is matches the errno and the io/fs errors it represents (e.g. fs.ErrPermission),
+// the original error stays reachable with errors.As (e.g. *errors.StatusError).
+type apiError struct {
+	errno syscall.Errno
+	err   error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func (e *apiError) Unwrap() []error {
+	return []error{e.errno, e.err}
+}
+
+// mapError maps err returned by a Kubernetes API request to a syscall.Errno
+// errors without a corresponding errno are returned unchanged
+//
+//nolint:gocyclo // flat mapping table
+func mapError(err error) error {
+	var errno syscall.Errno
+
+	switch {
+	case err == nil:
+		return nil
+	case apierr.IsNotFound(err):
+		errno = syscall.ENOENT
+	case apierr.IsAlreadyExists(err):
+		errno = syscall.EEXIST
+	case apierr.IsForbidden(err), apierr.IsUnauthorized(err):
+		errno = syscall.EACCES
+	case apierr.IsInvalid(err), apierr.IsBadRequest(err):
+		errno = syscall.EINVAL
+	case apierr.IsRequestEntityTooLargeError(err):
+		errno = syscall.EFBIG
+	case apierr.IsConflict(err), apierr.IsTooManyRequests(err), apierr.IsServiceUnavailable(err):
+		errno = syscall.EAGAIN
+	case apierr.IsTimeout(err), apierr.IsServerTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		errno = syscall.ETIMEDOUT
+	case apierr.IsMethodNotSupported(err):
+		errno = syscall.ENOTSUP
+	case apierr.IsInternalError(err):
+		errno = syscall.EIO
+	default:
+		return err
+	}
+
+	return &apiError{
+		errno: errno,
+		err:   err,
+	}
+}
@@ -0,0 +1,350 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// HistoryOfKey is the name of the annotation of a history secret referring to the secret it belongs to
+	// The history secret stores the previous revisions of the data of its secret, one key per revision.
+	HistoryOfKey = "history-of"
+	// CurrentRevision refers to the current data of a secret
+	CurrentRevision = 0
+)
+
+// Revision is a previous state of the data of a secret
+type Revision struct {
+	// ID of the revision, IDs are increasing
+	ID int
+	// ModTime is the modification time of the secret when the revision has been its data
+	ModTime time.Time
+}
+
+// Historian is implemented by backends keeping the revision history of the secrets
+type Historian interface {
+	// Revisions returns the revisions of the secret of m ordered by ID, only those with the key of m if it is not empty
+	Revisions(ctx context.Context, m Metadata) ([]Revision, error)
+	// GetRevision sets the data and modification time of revision id (CurrentRevision for the current data) on s
+	GetRevision(ctx context.Context, s Secret, id int) error
+}
+
+var _ Historian = (*backend)(nil)
+
+// ChangeType is the kind of change of a key between two states of a secret
+type ChangeType int
+
+// Changes of keys
+const (
+	Added ChangeType = iota + 1
+	Modified
+	Removed
+)
+
+// String returns the name of the change type
+func (t ChangeType) String() string {
+	switch t {
+	case Added:
+		return "added"
+	case Modified:
+		return "modified"
+	case Removed:
+		return "removed"
+	default:
+		return fmt.Sprintf("ChangeType(%d)", int(t))
+	}
+}
+
+// Change of a key between two states of a secret
+type Change struct {
+	Key  string
+	Type ChangeType
+}
+
+// Diff returns the changes of the keys from the data from to the data to ordered by key
+func Diff(from, to map[string][]byte) []Change {
+	changes := []Change{}
+
+	for k, v := range to {
+		old, ok := from[k]
+
+		switch {
+		case !ok:
+			changes = append(changes, Change{Key: k, Type: Added})
+		case !bytes.Equal(old, v):
+			changes = append(changes, Change{Key: k, Type: Modified})
+		}
+	}
+
+	for k := range from {
+		if _, ok := to[k]; !ok {
+			changes = append(changes, Change{Key: k, Type: Removed})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes
+}
+
+// revision is the value of a revision in the history secret
+// The data is stored as it has been stored in the secret, encrypted values stay encrypted.
+type revision struct {
+	ModTime    time.Time         `json:"modtime"`
+	Data       map[string][]byte `json:"data"`
+	Encryption string            `json:"encryption,omitempty"`
+}
+
+// Revisions returns the revisions of the secret of m ordered by ID (Historian)
+// If the key of m is not empty, only the revisions containing the key are returned.
+func (b *backend) Revisions(ctx context.Context, m Metadata) ([]Revision, error) {
+	h, err := b.history(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []Revision{}
+
+	for _, id := range revisionIDs(h) {
+		rev, err := decodeRevision(h, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := rev.Data[m.Key()]; m.Key() != "" && !ok {
+			continue
+		}
+
+		revisions = append(revisions, Revision{
+			ID:      id,
+			ModTime: rev.ModTime,
+		})
+	}
+
+	return revisions, nil
+}
+
+// GetRevision sets the data and modification time of revision id on s (Historian)
+// CurrentRevision gets the current data of the secret like Get does.
+func (b *backend) GetRevision(ctx context.Context, s Secret, id int) error {
+	if id == CurrentRevision {
+		return b.Get(ctx, s)
+	}
+
+	h, err := b.history(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := h.Data[strconv.Itoa(id)]; !ok {
+		return fmt.Errorf("%w: revision %d of %s", syscall.ENOENT, id, s.Secret())
+	}
+
+	rev, err := decodeRevision(h, id)
+	if err != nil {
+		return err
+	}
+
+	ks := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        h.Name,
+			Namespace:   h.Namespace,
+			Annotations: map[string]string{},
+		},
+		Data: rev.Data,
+	}
+
+	if rev.Encryption != "" {
+		ks.Annotations[EncryptionKey] = rev.Encryption
+	}
+
+	data, err := b.decode(ctx, ks)
+	if err != nil {
+		return err
+	}
+
+	s.SetData(data.Data)
+	s.SetTime(rev.ModTime)
+	s.SetResourceVersion("")
+
+	return nil
+}
+
+// history returns the history secret of the secret of m
+// the secret of m has to exist, an empty history is returned if there is no history secret
+func (b *backend) history(ctx context.Context, m Metadata) (*corev1.Secret, error) {
+	ks, err := b.read(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := b.companion(ctx, ks.Namespace, ks.Name, historyKind, HistoryOfKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if h == nil {
+		h = &corev1.Secret{}
+	}
+
+	return h, nil
+}
+
+// record stores the data of ks as the latest revision in the history secret of ks
+// The oldest revisions are removed until the history fits into the configured number of revisions
+// and size. A revision which does not fit into the history on its own is not recorded.
+func (b *backend) record(ctx context.Context, ks *corev1.Secret) error {
+	if b.revisions <= 0 {
+		return nil
+	}
+
+	data, err := b.stitch(ctx, ks)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(revision{
+		ModTime:    getTime(ks),
+		Data:       data.Data,
+		Encryption: ks.Annotations[EncryptionKey],
+	})
+	if err != nil {
+		return err
+	}
+
+	h, err := b.companion(ctx, ks.Namespace, ks.Name, historyKind, HistoryOfKey)
+	if err != nil {
+		return err
+	}
+
+	if h == nil {
+		h = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      companionName(ks.Name, historyKind),
+				Namespace: ks.Namespace,
+				Labels:    b.labels,
+				Annotations: map[string]string{
+					HistoryOfKey: ks.Name,
+				},
+			},
+			Data: map[string][]byte{},
+		}
+	}
+
+	ids := revisionIDs(h)
+	id := 1
+
+	if len(ids) > 0 {
+		latest := ids[len(ids)-1]
+
+		// the update has been retried after the revision has been recorded
+		if bytes.Equal(h.Data[strconv.Itoa(latest)], value) {
+			return nil
+		}
+
+		id = latest + 1
+	}
+
+	// a revision which does not fit into the history on its own is not recorded, the history is kept
+	alone := h.DeepCopy()
+	alone.Data = map[string][]byte{strconv.Itoa(id): value}
+
+	if b.size(alone) > b.historySize {
+		return nil
+	}
+
+	h.Data[strconv.Itoa(id)] = value
+	ids = append(ids, id)
+
+	changed := map[string][]byte{
+		strconv.Itoa(id): value,
+	}
+
+	// the new revision is the last one and fits, only older revisions are removed
+	for len(ids) > 1 && (len(ids) > b.revisions || b.size(h) > b.historySize) {
+		delete(h.Data, strconv.Itoa(ids[0]))
+		changed[strconv.Itoa(ids[0])] = nil
+		ids = ids[1:]
+	}
+
+	if h.ResourceVersion == "" {
+		return b.request(ctx, func(ctx context.Context) error {
+			_, err := b.r.create(ctx, h)
+			return err
+		})
+	}
+
+	p, err := dataPatch(b.r, changed, h.ResourceVersion, nil)
+	if err != nil {
+		return err
+	}
+
+	// the patch is idempotent because of the resourceVersion precondition
+	return b.retry(ctx, func(ctx context.Context) error {
+		_, err := b.r.patch(ctx, h.Namespace, h.Name, p)
+		return err
+	})
+}
+
+// renameHistory moves the history secret of o to n
+func (b *backend) renameHistory(ctx context.Context, o, n *corev1.Secret) error {
+	if b.revisions <= 0 {
+		return nil
+	}
+
+	return b.renameCompanion(ctx, o, n, historyKind, HistoryOfKey)
+}
+
+// deleteHistory removes the history secret of ks, a history which does not exist is not an error
+func (b *backend) deleteHistory(ctx context.Context, ks *corev1.Secret) error {
+	if b.revisions <= 0 {
+		return nil
+	}
+
+	h, err := b.companion(ctx, ks.Namespace, ks.Name, historyKind, HistoryOfKey)
+	if err != nil || h == nil {
+		return err
+	}
+
+	return b.deleteCompanion(ctx, h)
+}
+
+// revisionIDs returns the IDs of the revisions in the history secret h in ascending order
+func revisionIDs(h *corev1.Secret) []int {
+	ids := make([]int, 0, len(h.Data))
+
+	for k := range h.Data {
+		id, err := strconv.Atoi(k)
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+
+	return ids
+}
+
+func decodeRevision(h *corev1.Secret, id int) (*revision, error) {
+	rev := &revision{}
+
+	if err := json.Unmarshal(h.Data[strconv.Itoa(id)], rev); err != nil {
+		return nil, fmt.Errorf("%w: revision %d of %s: %v", syscall.EIO, id, h.Name, err)
+	}
+
+	return rev, nil
+}
@@ -0,0 +1,225 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// BackupOfKey is the name of the annotation of a backup secret referring to the secret it belongs to
+	// The backup holds the previous data of a secret while it is replaced, it is only kept if the secret
+	// can neither be replaced nor restored.
+	BackupOfKey = "backup-of"
+)
+
+// Replacer is implemented by backends which can replace the data of immutable secrets
+type Replacer interface {
+	// Replace replaces all keys of the secret of s with the data of s by recreating the secret
+	Replace(ctx context.Context, s Secret) error
+}
+
+var _ Replacer = (*backend)(nil)
+
+// Replace replaces all keys of the secret of s with the data of s (Replacer)
+// The secret is recreated with the same name, type, labels and immutability, so immutable secrets
+// can be changed. The new secret is prepared first (encryption and chunks) and a backup of the current
+// secret is created, then the current secret is deleted conditional on its resourceVersion and the new
+// one is created. If the new secret can not be created the previous one is restored, if that fails too
+// the backup secret <name>-backup-<hash> is kept and both errors are returned. Replace fails as long as
+// a backup exists. The chunks of the previous secret are removed last. Kubernetes can not rename secrets, so in
+// between the secret does not exist for the duration of one request.
+// With history the previous data is recorded as a revision.
+func (b *backend) Replace(ctx context.Context, s Secret) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, err := b.get(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	if err := ValidateData(old.Type, s.Data()); err != nil {
+		return err
+	}
+
+	ks := recreated(old)
+	ks.Data = s.Data()
+
+	delete(ks.Annotations, ChunksKey)
+	delete(ks.Annotations, EncryptionKey)
+	setCurrentTimes(ks)
+
+	if err := pruneAttributes(ks); err != nil {
+		return err
+	}
+
+	if err := b.encryptAll(ks); err != nil {
+		return err
+	}
+
+	var created []string
+
+	if b.chunking {
+		created, err = b.split(ctx, ks)
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.size(ks) > MaxSecretSize {
+		_ = b.deleteChunks(ctx, ks.Namespace, created)
+		return syscall.EFBIG
+	}
+
+	if err := b.record(ctx, old); err != nil {
+		_ = b.deleteChunks(ctx, ks.Namespace, created)
+		return err
+	}
+
+	if err := b.swap(ctx, old, ks); err != nil {
+		_ = b.deleteChunks(ctx, ks.Namespace, created)
+		return err
+	}
+
+	if err := b.deleteSecretChunks(ctx, old); err != nil {
+		return err
+	}
+
+	ks, err = b.get(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	data, err := b.decode(ctx, ks)
+	if err != nil {
+		return err
+	}
+
+	b.set(s, ks, data)
+
+	return nil
+}
+
+// swap deletes old if it has not been modified and creates ks instead
+// A backup of old is created first, hidden from the listings. If ks can not be created old is restored,
+// if that fails as well the backup is kept and both errors are returned. The backup is removed otherwise.
+func (b *backend) swap(ctx context.Context, old, ks *corev1.Secret) error {
+	backup := recreated(old)
+	backup.Name = companionName(old.Name, backupKind)
+	backup.Annotations[BackupOfKey] = old.Name
+
+	// a backup left by a failed Replace may hold the only copy of the data, it is not overwritten
+	err := b.request(ctx, func(ctx context.Context) error {
+		_, err := b.r.create(ctx, backup)
+		return err
+	})
+
+	if apierr.IsAlreadyExists(err) {
+		// a secret with the name which is not a backup of old is reported as such
+		if _, cerr := b.companion(ctx, old.Namespace, old.Name, backupKind, BackupOfKey); cerr != nil {
+			return cerr
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("backup %s: %w", backup.Name, err)
+	}
+
+	// not retried, the precondition would fail after a successful attempt
+	err = b.request(ctx, func(ctx context.Context) error {
+		return b.r.delete(ctx, old.Namespace, old.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{
+				ResourceVersion: &old.ResourceVersion,
+			},
+		})
+	})
+	if err != nil {
+		_ = b.deleteBackup(ctx, backup)
+
+		if apierr.IsConflict(err) {
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		}
+
+		return err
+	}
+
+	if b.cache != nil {
+		b.cache.delete(old.Namespace, old.Name)
+	}
+
+	err = b.createCached(ctx, ks)
+	if err == nil {
+		return b.deleteBackup(ctx, backup)
+	}
+
+	if rerr := b.createCached(ctx, recreated(old)); rerr != nil {
+		return errors.Join(err, fmt.Errorf("restore %s, the previous data is kept in %s: %w", old.Name, backup.Name, rerr))
+	}
+
+	_ = b.deleteBackup(ctx, backup)
+
+	return err
+}
+
+// createCached creates ks and stores it in the cache
+func (b *backend) createCached(ctx context.Context, ks *corev1.Secret) error {
+	return b.request(ctx, func(ctx context.Context) error {
+		ks, err := b.r.create(ctx, ks)
+		if err == nil {
+			b.cached(ks)
+		}
+
+		return err
+	})
+}
+
+// deleteBackup removes the backup created by swap
+func (b *backend) deleteBackup(ctx context.Context, backup *corev1.Secret) error {
+	err := b.retry(ctx, func(ctx context.Context) error {
+		return b.r.delete(ctx, backup.Namespace, backup.Name, metav1.DeleteOptions{})
+	})
+
+	if apierr.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// deleteBackupOf removes the backup of ks left by Replace, a backup which does not exist is not an error
+func (b *backend) deleteBackupOf(ctx context.Context, ks *corev1.Secret) error {
+	backup, err := b.companion(ctx, ks.Namespace, ks.Name, backupKind, BackupOfKey)
+	if err != nil || backup == nil {
+		return err
+	}
+
+	return b.deleteCompanion(ctx, backup)
+}
+
+// recreated returns a copy of ks without the fields set by the API server
+func recreated(ks *corev1.Secret) *corev1.Secret {
+	c := ks.DeepCopy()
+
+	if c.Annotations == nil {
+		c.Annotations = make(map[string]string)
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        c.Name,
+			Namespace:   c.Namespace,
+			Labels:      c.Labels,
+			Annotations: c.Annotations,
+		},
+		Immutable: c.Immutable,
+		Type:      c.Type,
+		Data:      c.Data,
+	}
+}
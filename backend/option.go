@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Option represents a functional Option
+type Option func(*backend)
+
+// WithSecretType configures the type of secrets created without a type
+func WithSecretType(t corev1.SecretType) Option {
+	return func(b *backend) {
+		b.secretType = t
+	}
+}
+
+// WithChunking configures the backend to store values which do not fit into a secret
+// in chunk secrets of at most ChunkSize bytes, see ChunksKey and ChunkOfKey
+func WithChunking() Option {
+	return func(b *backend) {
+		b.chunking = true
+	}
+}
+
+// WithImmutable configures the backend to create immutable secrets
+// Immutable secrets can not be updated, their data can only be replaced, see Replacer.
+func WithImmutable() Option {
+	return func(b *backend) {
+		b.immutable = true
+	}
+}
+
+// WithHistory configures the backend to record the previous data of a secret before it is changed
+// The revisions are stored in a history secret, see HistoryOfKey and Historian. The oldest revisions
+// are removed if there are more than revisions or the history secret is larger than size bytes
+// (at most MaxSecretSize, also if size is not positive).
+func WithHistory(revisions, size int) Option {
+	return func(b *backend) {
+		b.revisions = revisions
+		b.historySize = size
+
+		if size <= 0 || size > MaxSecretSize {
+			b.historySize = MaxSecretSize
+		}
+	}
+}
+
+// WithEncryption configures the backend to encrypt the values with AES-GCM before they are written
+// The keys are provided by kp, the algorithm and the ID of the key are stored in the annotation EncryptionKey.
+// Values encrypted with a previous key are decrypted as long as kp knows the key, see Reencrypter.
+// Keys validated by the API server (.dockerconfigjson and .dockercfg of the docker config types) can not be
+// encrypted, writing them fails with EINVAL.
+func WithEncryption(kp KeyProvider) Option {
+	return func(b *backend) {
+		b.keys = kp
+	}
+}
+
+// WithTimeout configures a custom request timeout
+func WithTimeout(t time.Duration) Option {
+	return func(b *backend) {
+		b.timeout = t
+	}
+}
+
+// WithRetry configures the retries of idempotent requests failing with transient errors
+// maxAttempts includes the first attempt, backoff is the initial delay which doubles after every attempt
+func WithRetry(maxAttempts int, backoff time.Duration) Option {
+	return func(b *backend) {
+		b.attempts = maxAttempts
+		b.backoff = backoff
+	}
+}
+
+// WithCache configures a read cache based on shared informers running until ctx is done
+// The cache is scoped to secrets with the labels in selector and to namespaces (all if empty).
+// Secrets not found in the cache are read from the API server. Secrets are only listed from the cache
+// if the configured labels include the labels in selector, otherwise the cache could miss some of them.
+func WithCache(ctx context.Context, selector map[string]string, namespaces ...string) Option {
+	return func(b *backend) {
+		b.cacheCtx = ctx
+		b.cacheSelector = selector
+		b.cacheNamespaces = namespaces
+	}
+}
+
+// WithIgnoreAnnotation configures the backend to ignore if the secret is managed with secfs or not
+func WithIgnoreAnnotation() Option {
+	return func(b *backend) {
+		b.ignoreAnnotation = true
+	}
+}
+
+// WithSecretPrefix configures a custom secret prefix
+func WithSecretPrefix(x string) Option {
+	return func(b *backend) {
+		b.prefix = x
+	}
+}
+
+// WithSecretSuffix configures a custom secret prefix
+func WithSecretSuffix(x string) Option {
+	return func(b *backend) {
+		b.suffix = x
+	}
+}
+
+// WithSecretLabels configures a custom secret labels
+func WithSecretLabels(labels map[string]string) Option {
+	return func(b *backend) {
+		b.labels = labels
+	}
+}
@@ -0,0 +1,234 @@
+package backend
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// resource is the Kubernetes resource the data is stored in
+// Resources other than secrets are converted from and to corev1.Secret,
+// so names, annotations, labels and merges are handled the same way for all of them.
+type resource interface {
+	get(ctx context.Context, namespace, name string) (*corev1.Secret, error)
+	// list returns the resources and the resourceVersion of the list
+	list(ctx context.Context, namespace string, opts metav1.ListOptions) ([]corev1.Secret, string, error)
+	create(ctx context.Context, ks *corev1.Secret) (*corev1.Secret, error)
+	patch(ctx context.Context, namespace, name string, p []byte) (*corev1.Secret, error)
+	delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error
+	watch(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error)
+
+	// dataPatch returns the fields of a JSON merge patch setting or removing (value nil) key
+	dataPatch(key string, value []byte) map[string]interface{}
+	// keySize returns the least size key with a value of length n adds to the stored resource
+	keySize(key string, n int) int
+
+	informer(f informers.SharedInformerFactory) cache.SharedIndexInformer
+	toSecret(obj interface{}) *corev1.Secret
+	fromSecret(ks *corev1.Secret) runtime.Object
+}
+
+// secrets stores the data in corev1.Secret
+type secrets struct {
+	c kubernetes.Interface
+}
+
+func (r secrets) get(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	return r.c.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (r secrets) list(ctx context.Context, namespace string, opts metav1.ListOptions) ([]corev1.Secret, string, error) {
+	l, err := r.c.CoreV1().Secrets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return l.Items, l.ResourceVersion, nil
+}
+
+func (r secrets) create(ctx context.Context, ks *corev1.Secret) (*corev1.Secret, error) {
+	return r.c.CoreV1().Secrets(ks.Namespace).Create(ctx, ks, metav1.CreateOptions{})
+}
+
+func (r secrets) patch(ctx context.Context, namespace, name string, p []byte) (*corev1.Secret, error) {
+	return r.c.CoreV1().Secrets(namespace).Patch(ctx, name, types.MergePatchType, p, metav1.PatchOptions{})
+}
+
+func (r secrets) delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+	return r.c.CoreV1().Secrets(namespace).Delete(ctx, name, opts)
+}
+
+func (r secrets) watch(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	return r.c.CoreV1().Secrets(namespace).Watch(ctx, opts)
+}
+
+func (r secrets) dataPatch(key string, value []byte) map[string]interface{} {
+	var v interface{} // null removes the key
+	if value != nil {
+		v = value
+	}
+
+	return map[string]interface{}{
+		"data": map[string]interface{}{
+			key: v,
+		},
+	}
+}
+
+func (r secrets) keySize(key string, n int) int {
+	return KeySize(key, n)
+}
+
+func (r secrets) informer(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+	return f.Core().V1().Secrets().Informer()
+}
+
+func (r secrets) toSecret(obj interface{}) *corev1.Secret {
+	return obj.(*corev1.Secret).DeepCopy()
+}
+
+func (r secrets) fromSecret(ks *corev1.Secret) runtime.Object {
+	return ks.DeepCopy()
+}
+
+// configMaps stores the data in corev1.ConfigMap
+// Values which are valid UTF-8 are stored in Data, all others in BinaryData.
+type configMaps struct {
+	c kubernetes.Interface
+}
+
+func (r configMaps) get(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	cm, err := r.c.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.toSecret(cm), nil
+}
+
+func (r configMaps) list(ctx context.Context, namespace string, opts metav1.ListOptions) ([]corev1.Secret, string, error) {
+	l, err := r.c.CoreV1().ConfigMaps(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	items := make([]corev1.Secret, 0, len(l.Items))
+	for i := range l.Items {
+		items = append(items, *r.toSecret(&l.Items[i]))
+	}
+
+	return items, l.ResourceVersion, nil
+}
+
+func (r configMaps) create(ctx context.Context, ks *corev1.Secret) (*corev1.Secret, error) {
+	cm, err := r.c.CoreV1().ConfigMaps(ks.Namespace).Create(ctx, r.fromSecret(ks).(*corev1.ConfigMap), metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.toSecret(cm), nil
+}
+
+func (r configMaps) patch(ctx context.Context, namespace, name string, p []byte) (*corev1.Secret, error) {
+	cm, err := r.c.CoreV1().ConfigMaps(namespace).Patch(ctx, name, types.MergePatchType, p, metav1.PatchOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.toSecret(cm), nil
+}
+
+func (r configMaps) delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+	return r.c.CoreV1().ConfigMaps(namespace).Delete(ctx, name, opts)
+}
+
+func (r configMaps) watch(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	return r.c.CoreV1().ConfigMaps(namespace).Watch(ctx, opts)
+}
+
+// dataPatch removes the key from the field it is not stored in, a key must not be in both
+func (r configMaps) dataPatch(key string, value []byte) map[string]interface{} {
+	var data, binaryData interface{} // null removes the key
+
+	switch {
+	case value == nil:
+	case utf8.Valid(value):
+		data = string(value)
+	default:
+		binaryData = value
+	}
+
+	return map[string]interface{}{
+		"data": map[string]interface{}{
+			key: data,
+		},
+		"binaryData": map[string]interface{}{
+			key: binaryData,
+		},
+	}
+}
+
+// keySize is the size of a UTF-8 value without characters escaped in JSON, binary values are larger
+func (r configMaps) keySize(key string, n int) int {
+	return len(key) + n + 6
+}
+
+func (r configMaps) informer(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+	return f.Core().V1().ConfigMaps().Informer()
+}
+
+func (r configMaps) toSecret(obj interface{}) *corev1.Secret {
+	cm := obj.(*corev1.ConfigMap)
+
+	ks := &corev1.Secret{
+		ObjectMeta: *cm.ObjectMeta.DeepCopy(),
+		Immutable:  cm.Immutable,
+		Data:       make(map[string][]byte, len(cm.Data)+len(cm.BinaryData)),
+	}
+
+	for k, v := range cm.Data {
+		ks.Data[k] = []byte(v)
+	}
+
+	for k, v := range cm.BinaryData {
+		ks.Data[k] = append([]byte{}, v...)
+	}
+
+	return ks
+}
+
+func (r configMaps) fromSecret(ks *corev1.Secret) runtime.Object {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: *ks.ObjectMeta.DeepCopy(),
+		Immutable:  ks.Immutable,
+	}
+
+	for k, v := range ks.Data {
+		if utf8.Valid(v) {
+			if cm.Data == nil {
+				cm.Data = make(map[string]string)
+			}
+
+			cm.Data[k] = string(v)
+
+			continue
+		}
+
+		if cm.BinaryData == nil {
+			cm.BinaryData = make(map[string][]byte)
+		}
+
+		cm.BinaryData[k] = append([]byte{}, v...)
+	}
+
+	return cm
+}
@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+const (
+	// DefaultRetryAttempts for idempotent k8s requests, 1 disables retries
+	DefaultRetryAttempts = 1
+	// DefaultRetryBackoff is the initial delay between attempts, it doubles after every attempt
+	DefaultRetryBackoff = 100 * time.Millisecond
+)
+
+// retry calls the idempotent request fn until it succeeds, fails with an error which is
+// not transient or the attempts are exhausted. A delay suggested by the API server with
+// Retry-After is preferred to the backoff.
+func (b *backend) retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	backoff := b.backoff
+
+	for attempt := 1; ; attempt++ {
+		err := b.request(ctx, fn)
+		if err == nil || attempt >= b.attempts || !isTransient(err) {
+			return err
+		}
+
+		delay := backoff
+		if s, ok := apierr.SuggestsClientDelay(err); ok && s > 0 {
+			delay = time.Duration(s) * time.Second
+		}
+
+		t := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+
+		backoff *= 2
+	}
+}
+
+// request calls fn with the request timeout applied to ctx
+// the returned error is mapped to a syscall.Errno if possible
+func (b *backend) request(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	return mapError(fn(ctx))
+}
+
+// isTransient returns true for errors which are likely to succeed if the request is repeated
+func isTransient(err error) bool {
+	// the deadline of the request timeout is exceeded, not the one of the caller
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	if _, ok := apierr.SuggestsClientDelay(err); ok {
+		return true
+	}
+
+	return apierr.IsTooManyRequests(err) ||
+		apierr.IsServiceUnavailable(err) ||
+		apierr.IsServerTimeout(err) ||
+		apierr.IsTimeout(err) ||
+		utilnet.IsConnectionReset(err) ||
+		utilnet.IsProbableEOF(err)
+}
@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// MaxSecretSize is the maximum encoded size of a secret including its metadata
+const MaxSecretSize = 1024 * 1024
+
+// EncodedSize returns the size of ks encoded as JSON, the values are base64 encoded
+func EncodedSize(ks *corev1.Secret) int {
+	return encodedSize(ks)
+}
+
+// KeySize returns the size key with a value of length n adds to an encoded secret
+// "key":"value", with the value base64 encoded
+func KeySize(key string, n int) int {
+	return len(key) + base64.StdEncoding.EncodedLen(n) + 6
+}
+
+// Sizer is implemented by backends which report the size of the stored secrets with Secret.SetSize
+type Sizer interface {
+	// KeySize returns the least size key with a value of length n adds to a stored secret
+	KeySize(key string, n int) int
+}
+
+var _ Sizer = (*backend)(nil)
+
+// KeySize returns the least size key with a value of length n adds to a stored secret (Sizer)
+// The size is exact for secrets, config maps store UTF-8 values as JSON strings which may need escaping.
+func (b *backend) KeySize(key string, n int) int {
+	return b.r.keySize(key, n)
+}
+
+// size returns the size of ks encoded as JSON as it is stored by the resource, e.g. as a config map
+func (b *backend) size(ks *corev1.Secret) int {
+	return encodedSize(b.r.fromSecret(ks))
+}
+
+// resize returns the size of ks as it is stored after key has been set to value or removed (value nil)
+func (b *backend) resize(ks *corev1.Secret, key string, value []byte) int {
+	old, ok := ks.Data[key]
+
+	if value == nil {
+		delete(ks.Data, key)
+	} else {
+		ks.Data[key] = value
+	}
+
+	size := b.size(ks)
+
+	if ok {
+		ks.Data[key] = old
+	} else {
+		delete(ks.Data, key)
+	}
+
+	return size
+}
+
+func encodedSize(obj runtime.Object) int {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return 0
+	}
+
+	return len(b)
+}
@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ErrRequiredKey for keys required by the type of a secret which are missing or about to be deleted
+var ErrRequiredKey = errors.New("required key")
+
+// requiredKeys returns the keys which must exist in secrets of type t, at least one key of each entry
+func requiredKeys(t corev1.SecretType) [][]string {
+	switch t {
+	case corev1.SecretTypeTLS:
+		return [][]string{{corev1.TLSCertKey}, {corev1.TLSPrivateKeyKey}}
+	case corev1.SecretTypeDockerConfigJson:
+		return [][]string{{corev1.DockerConfigJsonKey}}
+	case corev1.SecretTypeDockercfg:
+		return [][]string{{corev1.DockerConfigKey}}
+	case corev1.SecretTypeBasicAuth:
+		return [][]string{{corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey}}
+	case corev1.SecretTypeSSHAuth:
+		return [][]string{{corev1.SSHAuthPrivateKey}}
+	default:
+		return nil
+	}
+}
+
+// validatedKey returns true if the API server validates the content of key in secrets of type t,
+// such keys can not be encrypted
+func validatedKey(t corev1.SecretType, key string) bool {
+	switch t {
+	case corev1.SecretTypeDockerConfigJson:
+		return key == corev1.DockerConfigJsonKey
+	case corev1.SecretTypeDockercfg:
+		return key == corev1.DockerConfigKey
+	default:
+		return false
+	}
+}
+
+// ValidateData returns ErrRequiredKey if a key required by type t is missing in data
+// Basic-auth secrets require username or password, like the API server does.
+func ValidateData(t corev1.SecretType, data map[string][]byte) error {
+	for _, keys := range requiredKeys(t) {
+		if !containsAny(data, keys, "") {
+			return requiredKeyError(t, keys...)
+		}
+	}
+
+	return nil
+}
+
+// ValidateDelete returns ErrRequiredKey if key is required by type t in data, e.g. the password of
+// a basic-auth secret can be deleted only if it has a username
+func ValidateDelete(t corev1.SecretType, data map[string][]byte, key string) error {
+	for _, keys := range requiredKeys(t) {
+		if slices.Contains(keys, key) && !containsAny(data, keys, key) {
+			return requiredKeyError(t, key)
+		}
+	}
+
+	return nil
+}
+
+// containsAny returns true if data contains one of keys other than except
+func containsAny(data map[string][]byte, keys []string, except string) bool {
+	for _, k := range keys {
+		if _, ok := data[k]; ok && k != except {
+			return true
+		}
+	}
+
+	return false
+}
+
+func requiredKeyError(t corev1.SecretType, keys ...string) error {
+	quoted := make([]string, 0, len(keys))
+	for _, k := range keys {
+		quoted = append(quoted, fmt.Sprintf("%q", k))
+	}
+
+	return fmt.Errorf("%w %s of type %s", ErrRequiredKey, strings.Join(quoted, " or "), t)
+}
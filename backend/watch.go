@@ -0,0 +1,308 @@
+package backend
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// MaxWatchBackoff is the maximum delay before a failed watch is started again
+const MaxWatchBackoff = 30 * time.Second
+
+// SecretEvent is a change of the data of a secret observed by Watch
+// Old is nil for created secrets, New is nil for deleted secrets. Err reports a failed
+// watch request or a secret which can not be read (e.g. decrypted), the watch continues.
+type SecretEvent struct {
+	Namespace string
+	Secret    string
+	Old       map[string][]byte
+	New       map[string][]byte
+	Err       error
+}
+
+// Watcher is implemented by backends which can watch secrets
+type Watcher interface {
+	// Watch sends the changes of the secret of m, all secrets of the namespace of m if the secret is empty
+	// (all namespaces if the namespace is empty), until ctx is done. The channel is closed when ctx is done.
+	Watch(ctx context.Context, m Metadata) (<-chan SecretEvent, error)
+}
+
+var _ Watcher = (*backend)(nil)
+
+// Watch sends the changes of the data of the secrets of m (Watcher)
+// The secrets are listed first, events are sent for the changes afterwards. Changes of the metadata
+// only are not sent. A watch dropped by the API server is resumed with the last resourceVersion seen,
+// if the resourceVersion has expired the secrets are listed again and the changes in between are sent.
+func (b *backend) Watch(ctx context.Context, m Metadata) (<-chan SecretEvent, error) {
+	w := &secretWatch{
+		b:         b,
+		namespace: m.Namespace(),
+		state:     make(map[types.NamespacedName]map[string][]byte),
+		events:    make(chan SecretEvent),
+	}
+
+	if m.Secret() != "" {
+		w.name = b.internalName(m.Secret())
+	}
+
+	if err := w.relist(ctx, false); err != nil {
+		return nil, err
+	}
+
+	// the first watch is started before returning, so no change after Watch returned is missed
+	wi, err := w.start(ctx)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	go w.run(ctx, wi)
+
+	return w.events, nil
+}
+
+// secretWatch watches the secret name in namespace, all secrets of namespace if name is empty
+type secretWatch struct {
+	b         *backend
+	namespace string
+	name      string
+
+	rv     string                                     // resourceVersion to resume the watch from
+	state  map[types.NamespacedName]map[string][]byte // decoded data of the secrets
+	events chan SecretEvent
+}
+
+// run receives the events of wi and restarts the watch until ctx is done
+func (w *secretWatch) run(ctx context.Context, wi watch.Interface) {
+	defer close(w.events)
+
+	for {
+		err := w.receive(ctx, wi)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if apierr.IsResourceExpired(err) || apierr.IsGone(err) {
+			err = w.relist(ctx, true)
+		}
+
+		if err != nil && !w.fail(ctx, err) {
+			return
+		}
+
+		wi = w.restart(ctx)
+		if wi == nil {
+			return
+		}
+	}
+}
+
+// restart starts the watch again after a delay which doubles after every failed attempt
+// the secrets are listed again if the resourceVersion has expired, returns nil if ctx is done
+func (w *secretWatch) restart(ctx context.Context) watch.Interface {
+	backoff := w.b.backoff
+
+	for {
+		t := time.NewTimer(backoff)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+
+		wi, err := w.start(ctx)
+		if err == nil {
+			return wi
+		}
+
+		if apierr.IsResourceExpired(err) || apierr.IsGone(err) {
+			err = w.relist(ctx, true)
+		}
+
+		if err != nil && !w.fail(ctx, err) {
+			return nil
+		}
+
+		backoff = min(2*backoff, MaxWatchBackoff)
+	}
+}
+
+// start starts a watch from the last resourceVersion
+func (w *secretWatch) start(ctx context.Context) (watch.Interface, error) {
+	opts := w.options()
+	opts.ResourceVersion = w.rv
+	opts.AllowWatchBookmarks = true
+
+	return w.b.r.watch(ctx, w.namespace, opts)
+}
+
+// receive receives the events of wi until the watch is dropped or ctx is done
+func (w *secretWatch) receive(ctx context.Context, wi watch.Interface) error {
+	defer wi.Stop()
+
+	for {
+		var e watch.Event
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-wi.ResultChan():
+			if !ok {
+				return nil
+			}
+
+			e = ev
+		}
+
+		if e.Type == watch.Error {
+			return apierr.FromObject(e.Object)
+		}
+
+		if m, err := meta.Accessor(e.Object); err == nil && m.GetResourceVersion() != "" {
+			w.rv = m.GetResourceVersion()
+		}
+
+		if e.Type != watch.Added && e.Type != watch.Modified && e.Type != watch.Deleted {
+			continue
+		}
+
+		ks := w.b.r.toSecret(e.Object)
+		key := types.NamespacedName{Namespace: ks.Namespace, Name: ks.Name}
+
+		var ok bool
+
+		// secrets which are not watched (anymore) are removed, e.g. if a label has been removed
+		if e.Type == watch.Deleted || !w.watched(ks) {
+			ok = w.remove(ctx, key, true)
+		} else {
+			ok = w.update(ctx, ks, true)
+		}
+
+		if !ok {
+			return nil
+		}
+	}
+}
+
+// relist lists the secrets, the changes since the last state are sent if send is set
+func (w *secretWatch) relist(ctx context.Context, send bool) error {
+	var (
+		secrets []corev1.Secret
+		rv      string
+	)
+
+	err := w.b.retry(ctx, func(ctx context.Context) error {
+		var err error
+		secrets, rv, err = w.b.r.list(ctx, w.namespace, w.options())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[types.NamespacedName]bool, len(secrets))
+
+	for i := range secrets {
+		ks := &secrets[i]
+
+		if !w.watched(ks) {
+			continue
+		}
+
+		seen[types.NamespacedName{Namespace: ks.Namespace, Name: ks.Name}] = true
+
+		if !w.update(ctx, ks, send) {
+			return ctx.Err()
+		}
+	}
+
+	for key := range w.state {
+		if !seen[key] && !w.remove(ctx, key, send) {
+			return ctx.Err()
+		}
+	}
+
+	w.rv = rv
+
+	return nil
+}
+
+// update stores the data of ks and sends the change if send is set
+// returns false if ctx is done
+func (w *secretWatch) update(ctx context.Context, ks *corev1.Secret, send bool) bool {
+	key := types.NamespacedName{Namespace: ks.Namespace, Name: ks.Name}
+
+	data, err := w.b.decode(ctx, ks)
+	if err != nil {
+		return !send || w.send(ctx, SecretEvent{Namespace: ks.Namespace, Secret: w.b.externalName(ks.Name), Err: err})
+	}
+
+	n := data.Data
+	if n == nil {
+		n = make(map[string][]byte)
+	}
+
+	old, ok := w.state[key]
+	w.state[key] = n
+
+	if !send || (ok && len(Diff(old, n)) == 0) {
+		return true
+	}
+
+	return w.send(ctx, SecretEvent{Namespace: ks.Namespace, Secret: w.b.externalName(ks.Name), Old: old, New: n})
+}
+
+// remove removes the data of the secret key and sends the change if send is set
+// returns false if ctx is done
+func (w *secretWatch) remove(ctx context.Context, key types.NamespacedName, send bool) bool {
+	old, ok := w.state[key]
+	if !ok {
+		return true
+	}
+
+	delete(w.state, key)
+
+	return !send || w.send(ctx, SecretEvent{Namespace: key.Namespace, Secret: w.b.externalName(key.Name), Old: old})
+}
+
+// fail sends the error of a failed watch request, returns false if ctx is done
+func (w *secretWatch) fail(ctx context.Context, err error) bool {
+	return w.send(ctx, SecretEvent{Namespace: w.namespace, Secret: w.b.externalName(w.name), Err: mapError(err)})
+}
+
+// send returns false if ctx is done before e has been received
+func (w *secretWatch) send(ctx context.Context, e SecretEvent) bool {
+	select {
+	case w.events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// watched checks if ks is one of the watched secrets
+func (w *secretWatch) watched(ks *corev1.Secret) bool {
+	return w.b.listed(ks) && (w.name == "" || ks.Name == w.name)
+}
+
+func (w *secretWatch) options() metav1.ListOptions {
+	opts := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(w.b.labels).String(),
+	}
+
+	if w.name != "" {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.name).String()
+	}
+
+	return opts
+}
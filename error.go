@@ -5,6 +5,8 @@ import (
 	"io/fs"
 	"os"
 	"syscall"
+
+	"github.com/postfinance/secfs/backend"
 )
 
 var (
@@ -12,8 +14,24 @@ var (
 	ErrMoveCrossNamespace = errors.New("move a secret between namespaces is not allowed")
 	// ErrMoveConvert secrets can contain files only
 	ErrMoveConvert = errors.New("convert a secret to a file is not allowed")
+	// ErrConflict a key has been modified concurrently since it has been opened
+	ErrConflict = backend.ErrConflict
+	// ErrRequiredKey a key required by the type of the secret is missing or is about to be removed
+	ErrRequiredKey = backend.ErrRequiredKey
+	// ErrReadOnly the filesystem is read-only, matches syscall.EROFS and fs.ErrPermission
+	ErrReadOnly error = readOnlyError{}
 )
 
+type readOnlyError struct{}
+
+func (readOnlyError) Error() string {
+	return syscall.EROFS.Error()
+}
+
+func (readOnlyError) Unwrap() []error {
+	return []error{syscall.EROFS, fs.ErrPermission}
+}
+
 func wrapPathError(op, name string, err error) error {
 	switch err {
 	case nil:
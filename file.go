@@ -2,16 +2,19 @@ package secfs
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/postfinance/secfs/internal/backend"
+	"github.com/postfinance/secfs/backend"
 	"github.com/spf13/afero"
+	corev1 "k8s.io/api/core/v1"
 )
 
 // File is the corev1.Secret without k8s specific data
@@ -22,20 +25,32 @@ type File struct {
 	key   string
 	value []byte
 	data  map[string][]byte
+	dirs  []string // namespaces or secrets of a virtual directory
 
-	mtime time.Time
-	mode  fs.FileMode
+	mtime  time.Time
+	mtimes map[string]time.Time // modification times of the keys
+	mode   fs.FileMode
+	rv     string // resourceVersion of the secret data has been read from
+	size   int    // encoded size of the secret data has been read from, negative if not limited
 
-	readonly bool
-	closed   bool
-	delete   bool
+	readonly  bool
+	rofs      bool // opened on a read-only filesystem
+	immutable bool // the secret is immutable
+	closed    bool
+	delete    bool
 
 	pos int64
 
-	TLS bool // TODO: corev1.SecretTypeTLS
+	entries []os.FileInfo // sorted directory entries, read on the first Readdir
+	dirPos  int           // number of directory entries already returned
+	partial bool          // directory entry of a namespace or secret, Info reads the metadata
+
+	stype corev1.SecretType             // type of the secret
+	attrs map[string]backend.Attributes // attributes of the keys and the secret (empty key)
 
 	mu      sync.RWMutex
 	backend backend.Backend
+	ctx     context.Context // used for the backend requests of Sync and Close
 }
 
 func newFile(name string) (*File, error) {
@@ -44,7 +59,7 @@ func newFile(name string) (*File, error) {
 		return nil, err
 	}
 
-	mode := os.FileMode(0)
+	mode := DefaultFileMode
 	if p.IsDir() {
 		mode = os.ModeDir
 	}
@@ -64,15 +79,22 @@ func newFile(name string) (*File, error) {
 // https://pkg.go.dev/os#Open
 // returns *File (implements afero.File and os.FileInfo)
 func Open(b backend.Backend, name string) (*File, error) {
+	return OpenContext(context.Background(), b, name)
+}
+
+// OpenContext is like Open but uses ctx for all requests to the backend
+// including those of the returned *File
+func OpenContext(ctx context.Context, b backend.Backend, name string) (*File, error) {
 	f, err := newFile(name)
 	if err != nil {
 		return nil, wrapPathError("Open", name, err)
 	}
 
 	f.backend = b
+	f.ctx = ctx
 	f.readonly = true
 
-	if err := b.Get(f); err != nil {
+	if err := f.load(); err != nil {
 		return nil, wrapPathError("Open", name, err)
 	}
 
@@ -85,7 +107,8 @@ func Open(b backend.Backend, name string) (*File, error) {
 		return nil, wrapPathError("Open", name, syscall.ENOENT)
 	}
 
-	f.value = v
+	// copy the value, writes must not modify the data the file has been read with
+	f.value = bytes.Clone(v)
 
 	return f, nil
 }
@@ -94,31 +117,63 @@ func Open(b backend.Backend, name string) (*File, error) {
 // https://pkg.go.dev/os#Create
 // returns *File (implements afero.File and os.FileInfo)
 func FileCreate(b backend.Backend, name string) (*File, error) {
+	return FileCreateContext(context.Background(), b, name)
+}
+
+// FileCreateContext is like FileCreate but uses ctx for all requests to the backend
+// including those of the returned *File
+func FileCreateContext(ctx context.Context, b backend.Backend, name string) (*File, error) {
 	f, err := newFile(name)
 	if err != nil {
 		return nil, wrapPathError("Create", name, err)
 	}
 
 	f.backend = b
+	f.ctx = ctx
 	f.readonly = false
 
 	if f.IsDir() {
 		return nil, wrapPathError("Create", name, syscall.EISDIR)
 	}
 
-	if err := b.Get(f); err != nil {
+	if err := b.Get(ctx, f); err != nil {
 		return nil, wrapPathError("Create", name, err)
 	}
 
-	f.data[f.key] = make([]byte, 0)
+	if f.immutable {
+		return nil, wrapPathError("Create", name, syscall.EPERM)
+	}
+
+	if f.writeProtected() {
+		return nil, wrapPathError("Create", name, syscall.EACCES)
+	}
 
-	if err := b.Update(f); err != nil {
+	f.value = make([]byte, 0)
+
+	if err := b.Update(ctx, f); err != nil {
 		return nil, wrapPathError("Create", name, err)
 	}
 
 	return f, nil
 }
 
+// load reads the content of the file from the backend
+// the root directory lists the namespaces, a namespace directory lists the secrets
+func (f *File) load() error {
+	var err error
+
+	switch {
+	case f.spath.IsRoot():
+		f.dirs, err = f.backend.Namespaces(f.ctx)
+	case f.spath.IsNamespace():
+		f.dirs, err = f.backend.List(f.ctx, f.spath.Namespace())
+	default:
+		err = f.backend.Get(f.ctx, f)
+	}
+
+	return err
+}
+
 var _ backend.Secret = (*File)(nil) // backend.Secret includes backend.Metadata
 
 // Namespace returns the namespace name (backend.Metadata)
@@ -161,8 +216,87 @@ func (f *File) SetTime(mtime time.Time) {
 	f.mtime = mtime
 }
 
-var _ afero.File = (*File)(nil)  // https://pkg.go.dev/github.com/spf13/afero#File
-var _ os.FileInfo = (*File)(nil) // https://pkg.go.dev/io/fs#FileInfo
+// SetModTimes sets the modification times of the keys (backend.Secret)
+func (f *File) SetModTimes(mtimes map[string]time.Time) {
+	f.mtimes = mtimes
+}
+
+// SetSize sets the encoded size of the secret (backend.Secret)
+func (f *File) SetSize(size int) {
+	f.size = size
+}
+
+// Remaining returns the number of bytes the encoded secret can grow until it reaches
+// backend.MaxSecretSize, for keys the current value is taken into account.
+// Returns -1 if the size is not limited (chunking).
+func (f *File) Remaining() int64 {
+	if f.size < 0 {
+		return -1
+	}
+
+	return int64(backend.MaxSecretSize - f.encodedSize(len(f.value)))
+}
+
+// encodedSize returns the encoded size of the secret with a value of length n for the key
+// The size of the key is reported by the backend if it is a backend.Sizer, e.g. for config maps.
+func (f *File) encodedSize(n int) int {
+	size := f.size
+
+	if f.spath.IsDir() {
+		return size
+	}
+
+	keySize := backend.KeySize
+	if s, ok := f.backend.(backend.Sizer); ok {
+		keySize = s.KeySize
+	}
+
+	if v, ok := f.data[f.key]; ok {
+		size -= keySize(f.key, len(v))
+	}
+
+	return size + keySize(f.key, n)
+}
+
+// SecretType returns the type of the secret (backend.Secret)
+func (f *File) SecretType() corev1.SecretType {
+	return f.stype
+}
+
+// SetSecretType sets the type of the secret (backend.Secret)
+func (f *File) SetSecretType(t corev1.SecretType) {
+	f.stype = t
+}
+
+// ResourceVersion returns the resourceVersion of the secret (backend.Secret)
+func (f *File) ResourceVersion() string {
+	return f.rv
+}
+
+// SetResourceVersion sets the resourceVersion of the secret (backend.Secret)
+func (f *File) SetResourceVersion(rv string) {
+	f.rv = rv
+}
+
+// SetImmutable sets if the secret is immutable (backend.Secret)
+func (f *File) SetImmutable(immutable bool) {
+	f.immutable = immutable
+}
+
+// SetAttributes sets the attributes of the keys of the secret (backend.Secret)
+func (f *File) SetAttributes(attrs map[string]backend.Attributes) {
+	f.attrs = attrs
+}
+
+// Attributes returns the attributes of the key or secret set with Chmod, Chown and Chtimes
+func (f *File) Attributes() backend.Attributes {
+	return f.attrs[f.key]
+}
+
+var _ afero.File = (*File)(nil)     // https://pkg.go.dev/github.com/spf13/afero#File
+var _ os.FileInfo = (*File)(nil)    // https://pkg.go.dev/io/fs#FileInfo
+var _ fs.ReadDirFile = (*File)(nil) // https://pkg.go.dev/io/fs#ReadDirFile
+var _ fs.DirEntry = (*File)(nil)    // https://pkg.go.dev/io/fs#DirEntry
 
 // Close io.Closer
 func (f *File) Close() error {
@@ -276,6 +410,11 @@ func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
 	pLen := len(p)
 	expLen := off + int64(pLen)
 
+	// the API server would reject the secret on Sync or Close
+	if int64(len(f.value)) < expLen && f.size >= 0 && f.encodedSize(int(expLen)) > backend.MaxSecretSize {
+		return 0, syscall.EFBIG
+	}
+
 	if int64(len(f.value)) < expLen {
 		if int64(cap(f.value)) < expLen {
 			buf := make([]byte, expLen)
@@ -297,15 +436,58 @@ func (f *File) Name() string {
 }
 
 // Readdir (afero.File)
-// TODO: When return io.EOF?
+// The entries are returned sorted by name, subsequent calls continue after the
+// entries already returned. With count > 0 at most count entries are returned and
+// io.EOF at the end of the directory, with count <= 0 all remaining entries.
 func (f *File) Readdir(count int) ([]os.FileInfo, error) {
 	if !f.spath.IsDir() {
 		return nil, syscall.ENOTDIR
 	}
 
-	entries := []os.FileInfo{}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.entries == nil {
+		f.entries = f.readdirEntries()
+	}
+
+	remaining := f.entries[f.dirPos:]
+
+	if count <= 0 {
+		f.dirPos = len(f.entries)
+
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(remaining) {
+		count = len(remaining)
+	}
 
+	f.dirPos += count
+
+	return remaining[:count], nil
+}
+
+// readdirEntries returns all entries of the directory sorted by name
+func (f *File) readdirEntries() []os.FileInfo {
+	if f.spath.IsVirtual() {
+		return f.readdirVirtual()
+	}
+
+	names := make([]string, 0, len(f.data))
 	for n := range f.data {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	entries := make([]os.FileInfo, 0, len(names))
+
+	for _, n := range names {
 		p := &secretPath{
 			namespace: f.spath.Namespace(),
 			secret:    f.spath.Secret(),
@@ -313,17 +495,68 @@ func (f *File) Readdir(count int) ([]os.FileInfo, error) {
 			isDir:     false,
 		}
 
+		// the entry carries the value and modification time already read with the secret
 		entries = append(entries, &File{
-			name:  p.Absolute(),
-			spath: p,
+			name:      p.Absolute(),
+			spath:     p,
+			key:       n,
+			value:     f.data[n],
+			data:      f.data,
+			size:      f.size,
+			mtime:     f.mtime,
+			mtimes:    f.mtimes,
+			mode:      DefaultFileMode,
+			readonly:  true,
+			immutable: f.immutable,
+			attrs:     f.attrs,
 		})
+	}
+
+	return entries
+}
+
+// readdirVirtual returns the namespaces of the root or the secrets of a namespace directory
+func (f *File) readdirVirtual() []os.FileInfo {
+	names := append([]string{}, f.dirs...)
+	sort.Strings(names)
+
+	entries := make([]os.FileInfo, 0, len(names))
 
-		if count > 0 && len(entries) == count {
-			break
+	for _, n := range names {
+		p := &secretPath{
+			namespace: n,
+			isDir:     true,
+		}
+
+		if f.spath.IsNamespace() {
+			p.namespace = f.spath.Namespace()
+			p.secret = n
 		}
+
+		entries = append(entries, &File{
+			name:     p.Absolute(),
+			spath:    p,
+			mode:     os.ModeDir,
+			readonly: true,
+			partial:  true,
+			backend:  f.backend,
+			ctx:      f.ctx,
+		})
 	}
 
-	return entries, nil
+	return entries
+}
+
+// ReadDir is like Readdir but returns fs.DirEntry values (fs.ReadDirFile)
+func (f *File) ReadDir(count int) ([]fs.DirEntry, error) {
+	fi, err := f.Readdir(count)
+
+	entries := make([]fs.DirEntry, len(fi))
+	for i := range fi {
+		entries[i] = fi[i].(*File)
+	}
+
+	return entries, err
 }
 
 // Readdirnames (afero.File)
@@ -353,7 +586,7 @@ func (f *File) Sync() error {
 		return nil
 	}
 
-	return f.backend.Update(f)
+	return f.backend.Update(f.ctx, f)
 }
 
 // Truncate (afero.File)
@@ -389,7 +622,12 @@ func (f *File) WriteString(st string) (int, error) {
 }
 
 // Size returns length in bytes for keys (io.FileInfo)
+// and the number of entries for directories
 func (f *File) Size() int64 {
+	if f.spath.IsVirtual() {
+		return int64(len(f.dirs))
+	}
+
 	if f.spath.IsDir() {
 		return int64(len(f.data))
 	}
@@ -398,28 +636,88 @@ func (f *File) Size() int64 {
 }
 
 // Mode returns file mode bits (io.FileInfo)
+// The permissions set with Chmod replace the default ones, keys of immutable secrets are read-only.
 func (f *File) Mode() fs.FileMode {
-	return f.mode
+	mode := f.mode
+
+	if a := f.Attributes(); a.Mode != nil {
+		mode = mode.Type() | a.Mode.Perm()
+	}
+
+	if f.immutable {
+		return mode &^ 0o222
+	}
+
+	return mode
 }
 
 // ModTime returns file modification time (io.FileInfo)
+// Keys report the time they have been written or set with Chtimes. Secrets report the latest time
+// of their keys and of the last removal of a key, the time of the secret if there is none.
 func (f *File) ModTime() time.Time {
+	if !f.spath.IsDir() || f.spath.IsVirtual() {
+		return f.keyTime(f.key)
+	}
+
+	mtime, ok := f.mtimes[""]
+
+	for key := range f.data {
+		if t := f.keyTime(key); !ok || t.After(mtime) {
+			mtime, ok = t, true
+		}
+	}
+
+	if !ok {
+		return f.mtime
+	}
+
+	return mtime
+}
+
+// keyTime returns the modification time of key, keys without one are as old as the secret
+func (f *File) keyTime(key string) time.Time {
+	if t, ok := f.mtimes[key]; ok {
+		return t
+	}
+
 	return f.mtime
 }
 
-// IsDir returns true for a secret, false for a key (io.FileInfo)
+// IsDir returns true for the root, a namespace or a secret, false for a key (io.FileInfo)
 func (f *File) IsDir() bool {
 	return f.spath.IsDir()
 }
 
+// Type returns the type bits of the file mode (fs.DirEntry)
+func (f *File) Type() fs.FileMode {
+	return f.mode.Type()
+}
+
+// Info returns the FileInfo of a directory entry (fs.DirEntry)
+// Entries of namespaces and secrets are only named by the listing,
+// their metadata is read from the backend when Info is called.
+func (f *File) Info() (fs.FileInfo, error) {
+	if !f.partial {
+		return f, nil
+	}
+
+	return OpenContext(f.ctx, f.backend, f.name)
+}
+
 // Sys returns underlying data source (io.FileInfo)
-// can return nil
+// The *File is returned, the owner and access time set with Chown and Chtimes are reported by Attributes.
 func (f *File) Sys() interface{} {
 	return f
 }
 
 func (f *File) isEmptyDir() bool {
-	return f.spath.IsDir() && len(f.data) == 0
+	return f.spath.IsDir() && !f.spath.IsVirtual() && len(f.data) == 0
+}
+
+// writeProtected checks if the permissions set with Chmod deny writing
+func (f *File) writeProtected() bool {
+	a := f.Attributes()
+	return a.Mode != nil && a.Mode.Perm()&0o200 == 0
 }
 
 func (f *File) validateRO() error {
@@ -439,6 +737,14 @@ func (f *File) validateRW() error {
 		return err
 	}
 
+	if f.rofs {
+		return ErrReadOnly
+	}
+
+	if f.immutable {
+		return syscall.EPERM
+	}
+
 	if f.readonly {
 		/*
 			From the man page of truncate(2):
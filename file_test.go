@@ -1,6 +1,8 @@
 package secfs_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -11,7 +13,8 @@ import (
 	"testing"
 
 	"github.com/postfinance/secfs"
-	"github.com/postfinance/secfs/internal/backend"
+	"github.com/postfinance/secfs/backend"
+	"github.com/postfinance/secfs/internal/fakeclient"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
 )
@@ -24,16 +27,16 @@ func TestFileCreate(t *testing.T) {
 	filename := path.Join(namespace, secret, key)
 	secretname := path.Join(namespace, secret)
 
-	cs := backend.NewFakeClientset()
+	cs := fakeclient.New()
 	b := backend.New(cs,
-		backend.WithSecretPrefix(backend.FakePrefix),
-		backend.WithSecretSuffix(backend.FakeSuffix),
+		backend.WithSecretPrefix(fakeclient.Prefix),
+		backend.WithSecretSuffix(fakeclient.Suffix),
 	)
 
 	// prepare
 	sfs := secfs.New(cs,
-		secfs.WithSecretPrefix(backend.FakePrefix),
-		secfs.WithSecretSuffix(backend.FakeSuffix),
+		secfs.WithSecretPrefix(fakeclient.Prefix),
+		secfs.WithSecretSuffix(fakeclient.Suffix),
 	)
 
 	err := sfs.Mkdir(secretname, os.FileMode(0))
@@ -58,7 +61,7 @@ func TestFileCreate(t *testing.T) {
 		// interface os.FileInfo
 		require.Equal(t, key, f.Name())
 		require.Equal(t, int64(0), f.Size())
-		require.Equal(t, fs.FileMode(0), f.Mode())
+		require.Equal(t, secfs.DefaultFileMode, f.Mode())
 		require.False(t, f.ModTime().IsZero())
 		require.False(t, f.IsDir())
 		require.Equal(t, f, f.Sys())
@@ -90,7 +93,7 @@ func TestFileOpen(t *testing.T) {
 	filename := path.Join(namespace, secret, key)
 	secretname := path.Join(namespace, secret)
 
-	cs := backend.NewFakeClientset()
+	cs := fakeclient.New()
 	b := backend.New(cs)
 
 	// prepare
@@ -138,7 +141,7 @@ func TestFileOpen(t *testing.T) {
 		// interface os.FileInfo
 		require.Equal(t, key, f.Name())
 		require.Equal(t, int64(0), f.Size())
-		require.Equal(t, fs.FileMode(0), f.Mode())
+		require.Equal(t, secfs.DefaultFileMode, f.Mode())
 		require.False(t, f.ModTime().IsZero())
 		require.False(t, f.IsDir())
 		require.Equal(t, f, f.Sys())
@@ -156,7 +159,7 @@ func TestFileReadWriteSeek(t *testing.T) {
 	filename := path.Join(namespace, secret, key)
 	secretname := path.Join(namespace, secret)
 
-	cs := backend.NewFakeClientset()
+	cs := fakeclient.New()
 	b := backend.New(cs)
 
 	// prepare
@@ -484,7 +487,7 @@ func TestFileTruncate(t *testing.T) {
 	filename := path.Join(namespace, secret, key)
 	secretname := path.Join(namespace, secret)
 
-	cs := backend.NewFakeClientset()
+	cs := fakeclient.New()
 	b := backend.New(cs)
 
 	// prepare
@@ -598,7 +601,7 @@ func TestFileSync(t *testing.T) {
 	filename := path.Join(namespace, secret, key)
 	secretname := path.Join(namespace, secret)
 
-	cs := backend.NewFakeClientset()
+	cs := fakeclient.New()
 	b := backend.New(cs)
 
 	// prepare
@@ -644,7 +647,7 @@ func TestFileReaddir(t *testing.T) {
 
 	secretname := path.Join(namespace, secret)
 
-	cs := backend.NewFakeClientset()
+	cs := fakeclient.New()
 	b := backend.New(cs)
 
 	// prepare
@@ -693,6 +696,55 @@ func TestFileReaddir(t *testing.T) {
 		}
 	})
 
+	t.Run("Readdir cursor", func(t *testing.T) {
+		f, err := secfs.Open(b, secretname)
+		require.NoError(t, err)
+		require.NotNil(t, f)
+
+		n := []string{}
+
+		for {
+			fi, err := f.Readdir(3)
+			if errors.Is(err, io.EOF) {
+				require.Len(t, fi, 0)
+				break
+			}
+
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(fi), 3)
+
+			for i := range fi {
+				n = append(n, fi[i].Name())
+			}
+		}
+
+		require.Len(t, n, count)
+		require.True(t, sort.StringsAreSorted(n))
+
+		fi, err := f.Readdir(-1)
+		require.NoError(t, err)
+		require.Len(t, fi, 0)
+	})
+
+	t.Run("Readnames cursor", func(t *testing.T) {
+		f, err := secfs.Open(b, secretname)
+		require.NoError(t, err)
+		require.NotNil(t, f)
+
+		first, err := f.Readdirnames(4)
+		require.NoError(t, err)
+		require.Len(t, first, 4)
+
+		rest, err := f.Readdirnames(0)
+		require.NoError(t, err)
+		require.Len(t, rest, count-4)
+		require.True(t, sort.StringsAreSorted(append(first, rest...)))
+
+		n, err := f.Readdirnames(1)
+		require.ErrorIs(t, err, io.EOF)
+		require.Len(t, n, 0)
+	})
+
 	t.Run("Readnames not dir", func(t *testing.T) {
 		f, err := secfs.Open(b, path.Join(namespace, secret, key+"0"))
 		require.NoError(t, err)
@@ -719,3 +771,259 @@ func TestFileReaddir(t *testing.T) {
 		}
 	})
 }
+
+// ctxBackend fails all requests with canceled contexts like the real Kubernetes client
+type ctxBackend struct {
+	backend.Backend
+}
+
+func (b ctxBackend) Get(ctx context.Context, s backend.Secret) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return b.Backend.Get(ctx, s)
+}
+
+func (b ctxBackend) Update(ctx context.Context, s backend.Secret) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return b.Backend.Update(ctx, s)
+}
+
+func TestFileContext(t *testing.T) {
+	namespace := "default"
+	secret := "testsecret"
+	key := "testfile"
+
+	filename := path.Join(namespace, secret, key)
+	secretname := path.Join(namespace, secret)
+
+	cs := fakeclient.New()
+	b := ctxBackend{backend.New(cs)}
+
+	// prepare
+	sfs := secfs.New(cs)
+
+	err := sfs.Mkdir(secretname, os.FileMode(0))
+	require.NoError(t, err)
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		f, err := secfs.OpenContext(ctx, b, secretname)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Nil(t, f)
+
+		f, err = secfs.FileCreateContext(ctx, b, filename)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Nil(t, f)
+	})
+
+	t.Run("context canceled after open", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		f, err := secfs.FileCreateContext(ctx, b, filename)
+		require.NoError(t, err)
+
+		_, err = f.WriteString("value")
+		require.NoError(t, err)
+
+		cancel()
+
+		require.ErrorIs(t, f.Sync(), context.Canceled)
+	})
+
+	t.Run("WithContext", func(t *testing.T) {
+		mfs := afero.NewMemMapFs()
+		require.Equal(t, mfs, secfs.WithContext(context.Background(), mfs))
+
+		cfs := secfs.WithContext(context.Background(), sfs)
+		require.NotSame(t, sfs, cfs)
+
+		f, err := cfs.Open(filename)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	})
+}
+
+func TestFileConflict(t *testing.T) {
+	namespace := "default"
+	secret := "testsecret"
+
+	secretname := path.Join(namespace, secret)
+	filename1 := path.Join(namespace, secret, "testfile1")
+	filename2 := path.Join(namespace, secret, "testfile2")
+
+	cs := fakeclient.New()
+	b := backend.New(cs)
+
+	// prepare
+	sfs := secfs.New(cs)
+
+	err := sfs.Mkdir(secretname, os.FileMode(0))
+	require.NoError(t, err)
+
+	t.Run("write different keys", func(t *testing.T) {
+		f1, err := secfs.FileCreate(b, filename1)
+		require.NoError(t, err)
+
+		f2, err := secfs.FileCreate(b, filename2)
+		require.NoError(t, err)
+
+		_, err = f1.WriteString("value1")
+		require.NoError(t, err)
+
+		_, err = f2.WriteString("value2")
+		require.NoError(t, err)
+
+		require.NoError(t, f1.Close())
+		require.NoError(t, f2.Close())
+
+		c, err := afero.ReadFile(sfs, filename1)
+		require.NoError(t, err)
+		require.Equal(t, "value1", string(c))
+
+		c, err = afero.ReadFile(sfs, filename2)
+		require.NoError(t, err)
+		require.Equal(t, "value2", string(c))
+	})
+
+	t.Run("write stale key", func(t *testing.T) {
+		stale, err := sfs.OpenFile(filename1, os.O_RDWR, 0o0600)
+		require.NoError(t, err)
+
+		require.NoError(t, afero.WriteFile(sfs, filename1, []byte("newer"), 0o0600))
+
+		_, err = stale.WriteString("stale")
+		require.NoError(t, err)
+
+		require.ErrorIs(t, stale.Close(), secfs.ErrConflict)
+
+		c, err := afero.ReadFile(sfs, filename1)
+		require.NoError(t, err)
+		require.Equal(t, "newer", string(c))
+	})
+
+	t.Run("close unmodified stale key", func(t *testing.T) {
+		stale, err := sfs.OpenFile(filename1, os.O_RDWR, 0o0600)
+		require.NoError(t, err)
+
+		require.NoError(t, afero.WriteFile(sfs, filename1, []byte("newest"), 0o0600))
+
+		require.NoError(t, stale.Close())
+
+		c, err := afero.ReadFile(sfs, filename1)
+		require.NoError(t, err)
+		require.Equal(t, "newest", string(c))
+	})
+}
+
+func TestFileReaddirEntries(t *testing.T) {
+	namespace := "default"
+	secret := "testsecret"
+
+	secretname := path.Join(namespace, secret)
+
+	cs := fakeclient.New()
+	b := backend.New(cs)
+
+	// prepare
+	sfs := secfs.New(cs)
+
+	err := sfs.Mkdir(secretname, os.FileMode(0))
+	require.NoError(t, err)
+
+	values := map[string]string{
+		"empty": "",
+		"short": "0123",
+		"long":  "0123456789",
+	}
+
+	for k, v := range values {
+		require.NoError(t, afero.WriteFile(sfs, path.Join(secretname, k), []byte(v), 0o0600))
+	}
+
+	f, err := secfs.Open(b, secretname)
+	require.NoError(t, err)
+	require.NotNil(t, f)
+
+	fi, err := f.Readdir(-1)
+	require.NoError(t, err)
+	require.Len(t, fi, len(values))
+
+	for _, e := range fi {
+		st, err := sfs.Stat(path.Join(secretname, e.Name()))
+		require.NoError(t, err)
+
+		require.Equal(t, int64(len(values[e.Name()])), e.Size(), e.Name())
+		require.Equal(t, st.Size(), e.Size(), e.Name())
+		require.Equal(t, secfs.DefaultFileMode, e.Mode(), e.Name())
+		require.True(t, e.Mode().IsRegular(), e.Name())
+		require.Equal(t, st.Mode(), e.Mode(), e.Name())
+		require.False(t, e.ModTime().IsZero(), e.Name())
+		require.Equal(t, st.ModTime(), e.ModTime(), e.Name())
+		require.False(t, e.IsDir(), e.Name())
+	}
+}
+
+func TestFileSizeLimit(t *testing.T) {
+	cs := fakeclient.New()
+	b := backend.New(cs)
+
+	sfs := secfs.New(cs)
+	require.NoError(t, sfs.Mkdir("default/secret", 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/other", make([]byte, backend.MaxSecretSize/2), 0))
+
+	f, err := secfs.FileCreate(b, "default/secret/key")
+	require.NoError(t, err)
+
+	t.Run("Remaining", func(t *testing.T) {
+		before := f.Remaining()
+		require.Less(t, before, int64(backend.MaxSecretSize/2))
+
+		n, err := f.Write(make([]byte, 3000))
+		require.NoError(t, err)
+		require.Equal(t, 3000, n)
+		require.Equal(t, before-4000, f.Remaining())
+
+		fi, err := sfs.Stat("default/secret")
+		require.NoError(t, err)
+		require.Equal(t, before, fi.Sys().(*secfs.File).Remaining())
+	})
+
+	t.Run("Write beyond the limit", func(t *testing.T) {
+		_, err := f.Write(make([]byte, f.Remaining()))
+		require.ErrorIs(t, err, syscall.EFBIG)
+
+		_, err = f.WriteAt([]byte{0}, backend.MaxSecretSize)
+		require.ErrorIs(t, err, syscall.EFBIG)
+
+		n, err := f.WriteAt([]byte("overwrite"), 0)
+		require.NoError(t, err)
+		require.Equal(t, 9, n)
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		require.NoError(t, f.Close())
+
+		fi, err := sfs.Stat("default/secret/key")
+		require.NoError(t, err)
+		require.Equal(t, int64(3000), fi.Size())
+	})
+
+	t.Run("Sync beyond the limit", func(t *testing.T) {
+		g, err := sfs.OpenFile("default/secret/new", os.O_CREATE|os.O_WRONLY, 0)
+		require.NoError(t, err)
+
+		// another writer grows the secret after the file has been opened
+		require.NoError(t, afero.WriteFile(sfs, "default/secret/other", make([]byte, backend.MaxSecretSize*2/3), 0))
+
+		_, err = g.Write(make([]byte, backend.MaxSecretSize/8))
+		require.NoError(t, err)
+		require.ErrorIs(t, g.Sync(), syscall.EFBIG)
+	})
+}
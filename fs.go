@@ -1,4 +1,5 @@
 // Package secfs is a filesystem for k8s secrets
+// Root -> directory of namespaces
 // Namespace -> directory
 // Secret -> directory
 // Secret key -> file
@@ -6,6 +7,7 @@
 package secfs
 
 import (
+	"context"
 	"errors"
 	"io"
 	"io/fs"
@@ -14,8 +16,9 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/postfinance/secfs/internal/backend"
+	"github.com/postfinance/secfs/backend"
 	"github.com/spf13/afero"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -24,8 +27,14 @@ const (
 	DefaultSecretPrefix = ""
 	// DefaultSecretSuffix for k8s secrets
 	DefaultSecretSuffix = ""
+	// DefaultFileMode is the mode of keys, readable and writable by the owner only
+	DefaultFileMode = os.FileMode(0o600)
 	// DefaultRequestTimeout for k8s API requests
 	DefaultRequestTimeout = 5 * time.Second
+	// DefaultRetryAttempts for idempotent k8s API requests, 1 disables retries
+	DefaultRetryAttempts = 1
+	// DefaultRetryBackoff is the initial delay between attempts
+	DefaultRetryBackoff = 100 * time.Millisecond
 )
 
 // secfs implements afero.Fs for k8s secrets
@@ -35,33 +44,121 @@ type secfs struct {
 	suffix  string
 	labels  map[string]string
 	timeout time.Duration
+	ctx     context.Context
+
+	secretType corev1.SecretType
+	readonly   bool
+	chunking   bool
+	immutable  bool
+	keys       backend.KeyProvider
+
+	historyRevisions int
+	historySize      int
+
+	retryAttempts int
+	retryBackoff  time.Duration
+
+	cacheCtx        context.Context
+	cacheSelector   map[string]string
+	cacheNamespaces []string
 }
 
 var _ afero.Fs = (*secfs)(nil) // https://pkg.go.dev/github.com/spf13/afero#Fs
 
 // New returns a new afero.Fs for handling k8s secrets as files
 func New(k kubernetes.Interface, opts ...Option) afero.Fs {
+	return newFs(k, backend.New, opts...)
+}
+
+// NewConfigMapFs returns a new afero.Fs for handling k8s config maps as files
+// The directories and files behave the same as for secrets, the options
+// (prefix, suffix, labels, ...) apply to the config maps.
+// Values which are valid UTF-8 are stored in Data, all others in BinaryData.
+func NewConfigMapFs(k kubernetes.Interface, opts ...Option) afero.Fs {
+	return newFs(k, backend.NewConfigMap, opts...)
+}
+
+// NewWithBackend returns a new afero.Fs for the secrets stored in b
+// Only WithSecretType and WithReadOnly apply, all other options configure the Kubernetes backend
+// (prefix, suffix, labels, timeout, retry, cache, chunking, encryption, history and immutable secrets)
+// and are ignored here, the matching backend options have to be applied to b.
+func NewWithBackend(b backend.Backend, opts ...Option) afero.Fs {
+	s := &secfs{
+		backend: b,
+		ctx:     context.Background(),
+	}
+
+	for _, option := range opts {
+		option(s)
+	}
+
+	return s
+}
+
+func newFs(k kubernetes.Interface, newBackend func(kubernetes.Interface, ...backend.Option) backend.Backend, opts ...Option) afero.Fs {
 	s := &secfs{
-		backend: backend.New(k),
 		prefix:  DefaultSecretPrefix,
 		suffix:  DefaultSecretSuffix,
 		timeout: DefaultRequestTimeout,
+		ctx:     context.Background(),
+
+		retryAttempts: DefaultRetryAttempts,
+		retryBackoff:  DefaultRetryBackoff,
 	}
 
 	for _, option := range opts {
 		option(s)
 	}
 
-	s.backend = backend.New(k,
+	bopts := []backend.Option{
 		backend.WithSecretPrefix(s.prefix),
 		backend.WithSecretSuffix(s.suffix),
 		backend.WithSecretLabels(s.labels),
 		backend.WithTimeout(s.timeout),
-	)
+		backend.WithRetry(s.retryAttempts, s.retryBackoff),
+	}
+
+	if s.cacheCtx != nil {
+		bopts = append(bopts, backend.WithCache(s.cacheCtx, s.cacheSelector, s.cacheNamespaces...))
+	}
+
+	if s.chunking {
+		bopts = append(bopts, backend.WithChunking())
+	}
+
+	if s.immutable {
+		bopts = append(bopts, backend.WithImmutable())
+	}
+
+	if s.historyRevisions > 0 {
+		bopts = append(bopts, backend.WithHistory(s.historyRevisions, s.historySize))
+	}
+
+	if s.keys != nil {
+		bopts = append(bopts, backend.WithEncryption(s.keys))
+	}
+
+	s.backend = newBackend(k, bopts...)
 
 	return s
 }
 
+// WithContext returns a shallow copy of fsys which uses ctx for all requests to Kubernetes,
+// including the requests of the files opened with it.
+// Cancellation and deadlines of ctx are propagated, the request timeout is applied on top.
+// If fsys is not a secfs it is returned unchanged.
+func WithContext(ctx context.Context, fsys afero.Fs) afero.Fs {
+	s, ok := fsys.(*secfs)
+	if !ok {
+		return fsys
+	}
+
+	c := *s
+	c.ctx = ctx
+
+	return &c
+}
+
 // Name of this FileSystem.
 func (sfs secfs) Name() string {
 	return "secfs"
@@ -71,12 +168,37 @@ func (sfs secfs) Name() string {
 // returning the file/entry and an error, if any happens.
 // https://pkg.go.dev/os#Create
 func (sfs secfs) Create(name string) (afero.File, error) {
-	return FileCreate(sfs.backend, name)
+	if err := sfs.writable("Create", name); err != nil {
+		return nil, err
+	}
+
+	return FileCreateContext(sfs.ctx, sfs.backend, name)
+}
+
+// MkdirType creates a new secret of type t with data in fsys, like Mkdir does for the configured type
+// The keys required by the type have to be in data, otherwise ErrRequiredKey is returned before
+// the secret is created.
+// If fsys is not a secfs ENOTSUP is returned.
+func MkdirType(fsys afero.Fs, name string, t corev1.SecretType, data map[string][]byte) error {
+	s, ok := fsys.(*secfs)
+	if !ok {
+		return wrapPathError("Mkdir", name, syscall.ENOTSUP)
+	}
+
+	return s.mkdir(name, t, data)
 }
 
 // Mkdir creates a new, empty secret
 // return an error if any happens.
 func (sfs secfs) Mkdir(name string, _ os.FileMode) error {
+	return sfs.mkdir(name, sfs.secretType, nil)
+}
+
+func (sfs secfs) mkdir(name string, t corev1.SecretType, data map[string][]byte) error {
+	if err := sfs.writable("Mkdir", name); err != nil {
+		return err
+	}
+
 	s, err := newFile(name)
 	if err != nil {
 		return wrapPathError("Mkdir", name, err)
@@ -86,7 +208,7 @@ func (sfs secfs) Mkdir(name string, _ os.FileMode) error {
 		return wrapPathError("Mkdir", name, syscall.ENOTDIR)
 	}
 
-	_, err = Open(sfs.backend, name)
+	_, err = OpenContext(sfs.ctx, sfs.backend, name)
 
 	if err == nil {
 		return wrapPathError("Mkdir", name, syscall.EEXIST)
@@ -96,7 +218,18 @@ func (sfs secfs) Mkdir(name string, _ os.FileMode) error {
 		return wrapPathError("Mkdir", name, err)
 	}
 
-	return wrapPathError("Mkdir", name, sfs.backend.Create(s))
+	// namespaces are not managed with secfs
+	if s.spath.IsVirtual() {
+		return wrapPathError("Mkdir", name, syscall.EPERM)
+	}
+
+	s.stype = t
+
+	for k, v := range data {
+		s.data[k] = v
+	}
+
+	return wrapPathError("Mkdir", name, sfs.backend.Create(sfs.ctx, s))
 }
 
 // MkdirAll calls Mkdir
@@ -112,7 +245,14 @@ func (sfs secfs) MkdirAll(p string, perm os.FileMode) error {
 // Open opens a file, returning it or an error, if any happens.
 // https://pkg.go.dev/os#Open
 func (sfs secfs) Open(name string) (afero.File, error) {
-	return Open(sfs.backend, name)
+	f, err := OpenContext(sfs.ctx, sfs.backend, name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.rofs = sfs.readonly
+
+	return f, nil
 }
 
 // OpenFile opens a file using the given flags and the given mode.
@@ -121,6 +261,12 @@ func (sfs secfs) Open(name string) (afero.File, error) {
 //
 //nolint:gocyclo // complex function
 func (sfs secfs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) > 0 {
+		if err := sfs.writable("OpenFile", name); err != nil {
+			return nil, err
+		}
+	}
+
 	s, err := newFile(name)
 	if err != nil {
 		return nil, wrapPathError("OpenFile", name, err)
@@ -133,6 +279,16 @@ func (sfs secfs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, err
 		return f, nil
 	}
 
+	// keys of immutable secrets can not be written
+	if err == nil && f.(*File).immutable {
+		return nil, wrapPathError("OpenFile", name, syscall.EPERM)
+	}
+
+	// keys made read-only with Chmod can not be written
+	if err == nil && f.(*File).writeProtected() {
+		return nil, wrapPathError("OpenFile", name, syscall.EACCES)
+	}
+
 	// Ensure that this call creates the file:
 	// If O_EXCL is specified with O_CREAT, and pathname already exists, then  open() fails with the error EEXIST.
 	if err == nil && (flag&os.O_EXCL > 0) && (flag&os.O_CREATE > 0) {
@@ -140,7 +296,7 @@ func (sfs secfs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, err
 	}
 
 	// If pathname does not exist, create it as a regular file.
-	if os.IsNotExist(err) && (flag&os.O_CREATE > 0) {
+	if errors.Is(err, fs.ErrNotExist) && (flag&os.O_CREATE > 0) {
 		f, err = sfs.Create(name)
 	}
 
@@ -169,6 +325,10 @@ func (sfs secfs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, err
 
 // Remove removes an empty secret or a key identified by name.
 func (sfs secfs) Remove(name string) error {
+	if err := sfs.writable("Remove", name); err != nil {
+		return err
+	}
+
 	si, err := sfs.Stat(name)
 	if err != nil {
 		return wrapPathError("Remove", name, err)
@@ -176,13 +336,18 @@ func (sfs secfs) Remove(name string) error {
 
 	s := si.Sys().(*File)
 
+	// the root and namespaces can not be removed
+	if s.spath.IsVirtual() {
+		return wrapPathError("Remove", name, syscall.EPERM)
+	}
+
 	if si.IsDir() {
 		if !s.isEmptyDir() {
 			return wrapPathError("Remove", name, syscall.ENOTEMPTY)
 		}
 
 		// remove empty secret
-		if err := sfs.backend.Delete(s); err != nil {
+		if err := sfs.backend.Delete(sfs.ctx, s); err != nil {
 			return wrapPathError("Remove", name, err)
 		}
 
@@ -192,12 +357,16 @@ func (sfs secfs) Remove(name string) error {
 	// remove secret key
 	s.delete = true
 
-	return wrapPathError("Remove", name, sfs.backend.Update(s))
+	return wrapPathError("Remove", name, sfs.backend.Update(sfs.ctx, s))
 }
 
 // RemoveAll removes a secret or key with all it contains.
 // It does not fail if the path does not exist (return nil).
 func (sfs secfs) RemoveAll(name string) error {
+	if err := sfs.writable("RemoveAll", name); err != nil {
+		return err
+	}
+
 	si, err := sfs.Stat(name)
 	if errors.Is(err, afero.ErrFileNotFound) {
 		return nil
@@ -209,9 +378,14 @@ func (sfs secfs) RemoveAll(name string) error {
 
 	s := si.Sys().(*File)
 
+	// the root and namespaces can not be removed
+	if s.spath.IsVirtual() {
+		return wrapPathError("RemoveAll", name, syscall.EPERM)
+	}
+
 	if si.IsDir() {
 		// remove secret
-		if err := sfs.backend.Delete(s); err != nil {
+		if err := sfs.backend.Delete(sfs.ctx, s); err != nil {
 			return wrapPathError("RemoveAll", name, err)
 		}
 
@@ -221,11 +395,15 @@ func (sfs secfs) RemoveAll(name string) error {
 	// remove secret key
 	s.delete = true
 
-	return wrapPathError("RemoveAll", name, sfs.backend.Update(s))
+	return wrapPathError("RemoveAll", name, sfs.backend.Update(sfs.ctx, s))
 }
 
 // Rename moves old to new. Rename does not replace existing secrets or files.
 func (sfs secfs) Rename(o, n string) error {
+	if sfs.readonly {
+		return wrapLinkError("Rename", o, n, ErrReadOnly)
+	}
+
 	oldSp, err := newSecretPath(o)
 	if err != nil {
 		return wrapLinkError("Rename", o, n, err)
@@ -236,6 +414,11 @@ func (sfs secfs) Rename(o, n string) error {
 		return wrapLinkError("Rename", o, n, err)
 	}
 
+	// the root and namespaces can not be renamed or be the target of a rename
+	if oldSp.IsVirtual() || newSp.IsVirtual() {
+		return wrapLinkError("Rename", o, n, syscall.EPERM)
+	}
+
 	// move secret in a different namespace - currently not allowed
 	// ns1/sec1 -> ns2/sec2
 	// TODO: discuss
@@ -247,18 +430,32 @@ func (sfs secfs) Rename(o, n string) error {
 	// sec1 -> sec2
 	if oldSp.IsDir() {
 		if newSp.IsDir() {
-			return wrapLinkError("Rename", o, n, sfs.backend.Rename(oldSp, newSp))
+			return wrapLinkError("Rename", o, n, sfs.backend.Rename(sfs.ctx, oldSp, newSp))
 		}
 
 		return wrapLinkError("Rename", o, n, ErrMoveConvert)
 	}
 
 	// move/rename key
-	ofi, err := Open(sfs.backend, o)
+	ofi, err := OpenContext(sfs.ctx, sfs.backend, o)
 	if err != nil {
 		return wrapLinkError("Rename", o, n, err)
 	}
 
+	// the key is removed from the old secret
+	if ofi.immutable {
+		return wrapLinkError("Rename", o, n, syscall.EPERM)
+	}
+
+	if err := backend.ValidateDelete(ofi.stype, ofi.data, ofi.key); err != nil {
+		return wrapLinkError("Rename", o, n, err)
+	}
+
+	// sec1/key1 -> sec1/key2 // renamed with a single change if the backend supports it
+	if kr, ok := sfs.backend.(backend.KeyRenamer); ok && !newSp.IsDir() && oldSp.Secret() == newSp.Secret() {
+		return wrapLinkError("Rename", o, n, kr.RenameKey(sfs.ctx, oldSp, newSp))
+	}
+
 	// sec1/key1 -> sec2 // move key1 from sec1 to sec2 // sec2 must exist
 	// sec1/key1 -> sec1/key2 // rename key1 to key2 - key2 will be replaced
 	// sec1/key1 -> sec2/key2 // move key1 as key2 to sec2 // sec2 must exist, sec2/key2 will be replaced
@@ -268,7 +465,7 @@ func (sfs secfs) Rename(o, n string) error {
 	}
 
 	// create new item
-	nfi, err := FileCreate(sfs.backend, path.Join(newSp.Namespace(), newSp.Secret(), name))
+	nfi, err := FileCreateContext(sfs.ctx, sfs.backend, path.Join(newSp.Namespace(), newSp.Secret(), name))
 	if err != nil {
 		return wrapLinkError("Rename", o, n, err)
 	}
@@ -279,27 +476,201 @@ func (sfs secfs) Rename(o, n string) error {
 		return wrapLinkError("Rename", o, n, err)
 	}
 
+	// the key keeps its mode and owner
+	if err := sfs.moveAttributes(ofi, nfi); err != nil {
+		return wrapLinkError("Rename", o, n, err)
+	}
+
 	ofi.delete = true
 
-	return wrapLinkError("Rename", o, n, sfs.backend.Update(ofi))
+	return wrapLinkError("Rename", o, n, sfs.backend.Update(sfs.ctx, ofi))
+}
+
+// Replace replaces all keys of the secret name with data by recreating the secret, the type, labels
+// and immutability are kept. Immutable secrets can only be changed with Replace.
+// The new secret is prepared first and a backup of the current secret is created, the current secret is
+// deleted if it has not been modified concurrently (ErrConflict) and the new one is created. The current
+// secret is restored if this fails, if the restore fails as well the data is kept in the backup secret
+// <name>-backup and both errors are returned. See backend.Replacer.
+// If fsys is not a secfs or its backend does not implement backend.Replacer ENOTSUP is returned.
+func Replace(fsys afero.Fs, name string, data map[string][]byte) error {
+	s, ok := fsys.(*secfs)
+	if !ok {
+		return wrapPathError("Replace", name, syscall.ENOTSUP)
+	}
+
+	return s.replace(name, data)
+}
+
+func (sfs secfs) replace(name string, data map[string][]byte) error {
+	if err := sfs.writable("Replace", name); err != nil {
+		return err
+	}
+
+	r, ok := sfs.backend.(backend.Replacer)
+	if !ok {
+		return wrapPathError("Replace", name, syscall.ENOTSUP)
+	}
+
+	s, err := newFile(name)
+	if err != nil {
+		return wrapPathError("Replace", name, err)
+	}
+
+	if !s.IsDir() {
+		return wrapPathError("Replace", name, syscall.ENOTDIR)
+	}
+
+	// namespaces are not managed with secfs
+	if s.spath.IsVirtual() {
+		return wrapPathError("Replace", name, syscall.EPERM)
+	}
+
+	s.data = data
+
+	return wrapPathError("Replace", name, r.Replace(sfs.ctx, s))
+}
+
+// Reencrypt encrypts the values of name with the current key of the backend.KeyProvider configured with WithEncryption
+// name is a key, a secret (all keys) or a namespace (all secrets), keys which are not encrypted yet are encrypted.
+// If fsys is not a secfs or its backend does not implement backend.Reencrypter ENOTSUP is returned.
+func Reencrypt(fsys afero.Fs, name string) error {
+	s, ok := fsys.(*secfs)
+	if !ok {
+		return wrapPathError("Reencrypt", name, syscall.ENOTSUP)
+	}
+
+	return s.reencrypt(name)
+}
+
+func (sfs secfs) reencrypt(name string) error {
+	if err := sfs.writable("Reencrypt", name); err != nil {
+		return err
+	}
+
+	r, ok := sfs.backend.(backend.Reencrypter)
+	if !ok {
+		return wrapPathError("Reencrypt", name, syscall.ENOTSUP)
+	}
+
+	sp, err := newSecretPath(name)
+	if err != nil {
+		return wrapPathError("Reencrypt", name, err)
+	}
+
+	switch {
+	case sp.IsRoot():
+		return wrapPathError("Reencrypt", name, syscall.EPERM)
+	case sp.IsNamespace():
+		secrets, err := sfs.backend.List(sfs.ctx, sp.Namespace())
+		if err != nil {
+			return wrapPathError("Reencrypt", name, err)
+		}
+
+		for _, secret := range secrets {
+			if err := sfs.reencrypt(path.Join(sp.Namespace(), secret)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return wrapPathError("Reencrypt", name, r.Reencrypt(sfs.ctx, sp))
+	}
 }
 
 // Stat returns a FileInfo describing the named secret/key, or an error.
 func (sfs secfs) Stat(name string) (os.FileInfo, error) {
-	return Open(sfs.backend, name)
+	return OpenContext(sfs.ctx, sfs.backend, name)
 }
 
-// Chmod changes the mode of the named file to mode.
-func (sfs secfs) Chmod(_ string, _ os.FileMode) error {
-	return nil
+// Chmod changes the permissions of the named key or secret to the permissions of mode.
+// The permissions are stored in the annotation attributes of the secret and reported by Stat,
+// keys without write permission for the owner can not be opened for writing (EACCES).
+// If the backend does not implement backend.Attributer ENOTSUP is returned.
+func (sfs secfs) Chmod(name string, mode os.FileMode) error {
+	perm := mode.Perm()
+
+	return sfs.setAttributes("Chmod", name, backend.Attributes{Mode: &perm})
 }
 
-// Chown changes the uid and gid of the named file.
-func (sfs secfs) Chown(_ string, _, _ int) error {
-	return nil
+// Chown changes the uid and gid of the named key or secret, a negative id is not changed.
+// The ids are stored in the annotation attributes of the secret and reported by File.Attributes.
+// If the backend does not implement backend.Attributer ENOTSUP is returned.
+func (sfs secfs) Chown(name string, uid, gid int) error {
+	a := backend.Attributes{}
+
+	if uid >= 0 {
+		a.UID = &uid
+	}
+
+	if gid >= 0 {
+		a.GID = &gid
+	}
+
+	return sfs.setAttributes("Chown", name, a)
+}
+
+// Chtimes changes the access and modification times of the named key or secret, a zero time is not changed.
+// The times are stored in the annotation attributes of the secret, the modification time is reported
+// by Stat until the key is written, the access time by File.Attributes.
+// If the backend does not implement backend.Attributer ENOTSUP is returned.
+func (sfs secfs) Chtimes(name string, atime, mtime time.Time) error {
+	a := backend.Attributes{}
+
+	if !atime.IsZero() {
+		a.Atime = &atime
+	}
+
+	if !mtime.IsZero() {
+		a.Mtime = &mtime
+	}
+
+	return sfs.setAttributes("Chtimes", name, a)
+}
+
+// moveAttributes sets the mode and owner of the key of o on the key of n
+func (sfs secfs) moveAttributes(o, n *File) error {
+	a := o.Attributes()
+	a.Atime, a.Mtime = nil, nil
+
+	at, ok := sfs.backend.(backend.Attributer)
+	if !ok || (a == backend.Attributes{}) {
+		return nil
+	}
+
+	return at.SetAttributes(sfs.ctx, n, a)
+}
+
+// setAttributes sets the fields set in a on the attributes of the key or secret name
+func (sfs secfs) setAttributes(op, name string, a backend.Attributes) error {
+	if err := sfs.writable(op, name); err != nil {
+		return err
+	}
+
+	at, ok := sfs.backend.(backend.Attributer)
+	if !ok {
+		return wrapPathError(op, name, syscall.ENOTSUP)
+	}
+
+	sp, err := newSecretPath(name)
+	if err != nil {
+		return wrapPathError(op, name, err)
+	}
+
+	// namespaces are not managed with secfs
+	if sp.IsVirtual() {
+		return wrapPathError(op, name, syscall.EPERM)
+	}
+
+	return wrapPathError(op, name, at.SetAttributes(sfs.ctx, sp, a))
 }
 
-// Chtimes changes the access and modification times of the named file
-func (sfs secfs) Chtimes(_ string, _, _ time.Time) error {
+// writable returns ErrReadOnly for a read-only filesystem
+func (sfs secfs) writable(op, name string) error {
+	if sfs.readonly {
+		return wrapPathError(op, name, ErrReadOnly)
+	}
+
 	return nil
 }
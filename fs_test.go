@@ -1,19 +1,33 @@
 package secfs_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/postfinance/secfs"
-	"github.com/postfinance/secfs/internal/backend"
+	"github.com/postfinance/secfs/backend"
+	"github.com/postfinance/secfs/internal/fakeclient"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestFSName(t *testing.T) {
@@ -23,17 +37,6 @@ func TestFSName(t *testing.T) {
 	t.Run("Name", func(t *testing.T) {
 		assert.Equal(t, "secfs", sfs.Name())
 	})
-
-	t.Run("Unimplemented", func(t *testing.T) {
-		namespace := "default"
-		secret := "testsecret"
-
-		secretname := path.Join(namespace, secret)
-
-		require.NoError(t, sfs.Chmod(secretname, 0o0777))
-		require.NoError(t, sfs.Chown(secretname, 0, 0))
-		require.NoError(t, sfs.Chtimes(secretname, time.Now(), time.Now()))
-	})
 }
 
 func TestFSCreate(t *testing.T) {
@@ -44,7 +47,7 @@ func TestFSCreate(t *testing.T) {
 	secretname := path.Join(namespace, secret)
 	filename := path.Join(namespace, secret, key)
 
-	sfs := secfs.New(backend.NewFakeClientset())
+	sfs := secfs.New(fakeclient.New())
 	require.NotNil(t, sfs)
 
 	t.Run("Create secret", func(t *testing.T) {
@@ -80,7 +83,7 @@ func TestFSCreate(t *testing.T) {
 
 		require.Equal(t, key, st.Name())
 		require.Equal(t, int64(0), st.Size())
-		require.Equal(t, fs.FileMode(0), st.Mode())
+		require.Equal(t, secfs.DefaultFileMode, st.Mode())
 		require.False(t, st.ModTime().IsZero())
 		require.False(t, st.IsDir())
 		// require.Equal(t, st, st.Sys())
@@ -95,7 +98,7 @@ func TestFSOpen(t *testing.T) {
 	secretname := path.Join(namespace, secret)
 	filename := path.Join(namespace, secret, key)
 
-	sfs := secfs.New(backend.NewFakeClientset())
+	sfs := secfs.New(fakeclient.New())
 	require.NotNil(t, sfs)
 
 	err := sfs.Mkdir(secretname, os.FileMode(0))
@@ -133,7 +136,7 @@ func TestFSOpen(t *testing.T) {
 
 		require.Equal(t, key, st.Name())
 		require.Equal(t, int64(0), st.Size())
-		require.Equal(t, fs.FileMode(0), st.Mode())
+		require.Equal(t, secfs.DefaultFileMode, st.Mode())
 		require.False(t, st.ModTime().IsZero())
 		require.False(t, st.IsDir())
 		// require.Equal(t, st, st.Sys())
@@ -147,7 +150,7 @@ func TestFSOpenFile(t *testing.T) {
 	secretname := path.Join(namespace, secret)
 	filename := path.Join(namespace, secret, key)
 
-	sfs := secfs.New(backend.NewFakeClientset())
+	sfs := secfs.New(fakeclient.New())
 	require.NotNil(t, sfs)
 
 	err := sfs.Mkdir(secretname, os.FileMode(0))
@@ -267,7 +270,7 @@ func TestFSOpenFile(t *testing.T) {
 }
 
 func TestFSRemove(t *testing.T) {
-	sfs := secfs.New(backend.NewFakeClientset())
+	sfs := secfs.New(fakeclient.New())
 	require.NotNil(t, sfs)
 
 	t.Run("Remove", func(t *testing.T) {
@@ -338,7 +341,7 @@ func TestFSRemove(t *testing.T) {
 }
 
 func TestFSRename(t *testing.T) {
-	sfs := secfs.New(backend.NewFakeClientset())
+	sfs := secfs.New(fakeclient.New())
 	require.NotNil(t, sfs)
 
 	t.Run("Rename with different namespace", func(t *testing.T) {
@@ -458,3 +461,1278 @@ func TestFSRename(t *testing.T) {
 		require.NotNil(t, f)
 	})
 }
+
+func TestFSRootAndNamespace(t *testing.T) {
+	cs := fakeclient.New()
+
+	_, err := cs.CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "scratch",
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	sfs := secfs.New(cs,
+		secfs.WithSecretPrefix(fakeclient.Prefix),
+		secfs.WithSecretSuffix(fakeclient.Suffix),
+	)
+	require.NotNil(t, sfs)
+
+	files := []string{
+		"default/secret1/tls.crt",
+		"default/secret1/tls.key",
+		"default/secret2/tls.crt",
+		"scratch/secret3/password",
+	}
+
+	for _, n := range files {
+		require.NoError(t, sfs.MkdirAll(path.Dir(n), os.FileMode(0)))
+
+		f, err := sfs.Create(n)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	t.Run("Stat root and namespace", func(t *testing.T) {
+		fi, err := sfs.Stat("/")
+		require.NoError(t, err)
+		require.True(t, fi.IsDir())
+		require.Equal(t, "/", fi.Name())
+		require.Equal(t, int64(2), fi.Size())
+
+		fi, err = sfs.Stat("/default")
+		require.NoError(t, err)
+		require.True(t, fi.IsDir())
+		require.Equal(t, "default", fi.Name())
+		require.Equal(t, int64(2), fi.Size())
+
+		_, err = sfs.Stat("/notexisting")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("Readdir root and namespace", func(t *testing.T) {
+		f, err := sfs.Open("/")
+		require.NoError(t, err)
+
+		n, err := f.Readdirnames(-1)
+		require.NoError(t, err)
+
+		sort.Strings(n)
+		require.Equal(t, []string{"default", "scratch"}, n)
+
+		f, err = sfs.Open("/default")
+		require.NoError(t, err)
+
+		fi, err := f.Readdir(-1)
+		require.NoError(t, err)
+		require.Len(t, fi, 2)
+
+		// prefix and suffix are stripped, secrets not managed with secfs are not listed
+		n = []string{fi[0].Name(), fi[1].Name()}
+		sort.Strings(n)
+		require.Equal(t, []string{"secret1", "secret2"}, n)
+		require.True(t, fi[0].IsDir())
+	})
+
+	t.Run("afero.Walk and afero.Glob", func(t *testing.T) {
+		act := []string{}
+
+		err := afero.Walk(sfs, "/", func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.IsDir() {
+				act = append(act, strings.TrimPrefix(p, "/"))
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, files, act)
+
+		m, err := afero.Glob(sfs, "/*/*/tls.crt")
+		require.NoError(t, err)
+		require.Equal(t, []string{"/default/secret1/tls.crt", "/default/secret2/tls.crt"}, m)
+	})
+
+	t.Run("Modify root and namespace", func(t *testing.T) {
+		require.ErrorIs(t, sfs.Mkdir("/default", os.FileMode(0)), fs.ErrExist)
+		require.ErrorIs(t, sfs.Mkdir("/notexisting", os.FileMode(0)), fs.ErrPermission)
+		require.ErrorIs(t, sfs.Remove("/default"), fs.ErrPermission)
+		require.ErrorIs(t, sfs.RemoveAll("/"), fs.ErrPermission)
+		require.ErrorIs(t, sfs.Rename("/default/secret1", "/default"), fs.ErrPermission)
+
+		_, err := sfs.Create("/default")
+		require.ErrorIs(t, err, syscall.EISDIR)
+	})
+}
+
+func TestFSRetry(t *testing.T) {
+	cs := fakeclient.New()
+
+	sfs := secfs.New(cs, secfs.WithRetry(3, time.Millisecond))
+	require.NotNil(t, sfs)
+
+	filename := "default/testsecret/testfile"
+
+	require.NoError(t, sfs.Mkdir(path.Dir(filename), os.FileMode(0)))
+
+	f, err := sfs.Create(filename)
+	require.NoError(t, err)
+
+	_, err = f.WriteString("value")
+	require.NoError(t, err)
+
+	failures := 2
+
+	cs.PrependReactor("patch", "secrets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if failures == 0 {
+			return false, nil, nil
+		}
+
+		failures--
+
+		return true, nil, apierr.NewServiceUnavailable("unavailable")
+	})
+
+	require.NoError(t, f.Close())
+	require.Zero(t, failures)
+
+	c, err := afero.ReadFile(sfs, filename)
+	require.NoError(t, err)
+	require.Equal(t, "value", string(c))
+}
+
+func TestFSErrors(t *testing.T) {
+	cs := fakeclient.New()
+
+	sfs := secfs.New(cs)
+	require.NotNil(t, sfs)
+
+	require.NoError(t, sfs.Mkdir("default/testsecret", os.FileMode(0)))
+
+	cs.PrependReactor("get", "secrets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierr.NewForbidden(corev1.Resource("secrets"), "testsecret", errors.New("denied"))
+	})
+
+	_, err := sfs.Stat("default/testsecret")
+	require.ErrorIs(t, err, fs.ErrPermission)
+	require.ErrorIs(t, err, syscall.EACCES)
+
+	var pathErr *fs.PathError
+	require.ErrorAs(t, err, &pathErr)
+	require.Equal(t, "default/testsecret", pathErr.Path)
+
+	var status apierr.APIStatus
+	require.ErrorAs(t, err, &status)
+	require.True(t, apierr.IsForbidden(err))
+}
+
+func TestFSCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs := fakeclient.New()
+
+	sfs := secfs.New(cs, secfs.WithCache(ctx, nil))
+	require.NotNil(t, sfs)
+
+	files := []string{
+		"default/secret1/key1",
+		"default/secret1/key2",
+		"default/secret2/key1",
+	}
+
+	for _, n := range files {
+		require.NoError(t, sfs.MkdirAll(path.Dir(n), os.FileMode(0)))
+		require.NoError(t, afero.WriteFile(sfs, n, []byte(n), 0o0600))
+	}
+
+	cs.ClearActions()
+
+	act := []string{}
+
+	err := afero.Walk(sfs, "/default", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			c, err := afero.ReadFile(sfs, p)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimPrefix(p, "/"), string(c))
+
+			act = append(act, strings.TrimPrefix(p, "/"))
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, files, act)
+
+	for _, a := range cs.Actions() {
+		require.NotEqual(t, "secrets", a.GetResource().Resource, "%s secrets not served from cache", a.GetVerb())
+	}
+}
+
+func TestFSCacheSelector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs := fakeclient.New()
+
+	require.NoError(t, secfs.New(cs).Mkdir("default/s1", 0))
+	require.NoError(t, afero.WriteFile(secfs.New(cs), "default/s1/k", []byte("v"), 0))
+
+	t.Run("not covered", func(t *testing.T) {
+		// the secret without labels is not cached, it is listed from the API server
+		sfs := secfs.New(cs, secfs.WithCache(ctx, map[string]string{"team": "a"}))
+
+		_, err := sfs.Stat("default/s1/k")
+		require.NoError(t, err)
+
+		entries, err := afero.ReadDir(sfs, "default")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, "s1", entries[0].Name())
+	})
+
+	t.Run("covered", func(t *testing.T) {
+		sfs := secfs.New(cs, secfs.WithSecretLabels(map[string]string{"team": "a"}), secfs.WithCache(ctx, map[string]string{"team": "a"}))
+		require.NoError(t, sfs.Mkdir("default/s2", 0))
+
+		cs.ClearActions()
+
+		entries, err := afero.ReadDir(sfs, "default")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, "s2", entries[0].Name())
+
+		for _, a := range cs.Actions() {
+			require.False(t, a.GetVerb() == "list" && a.GetResource().Resource == "secrets", "secrets not listed from cache")
+		}
+	})
+}
+
+func TestFSConfigMap(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	sfs := secfs.NewConfigMapFs(cs, secfs.WithSecretPrefix("cm-"))
+
+	require.NoError(t, sfs.Mkdir("default/config", 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/config/app.yaml", []byte("key: value"), 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/config/logo.bin", []byte{0xff, 0xd8}, 0))
+
+	cm, err := cs.CoreV1().ConfigMaps("default").Get(ctx, "cm-config", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"app.yaml": "key: value"}, cm.Data)
+	require.Equal(t, map[string][]byte{"logo.bin": {0xff, 0xd8}}, cm.BinaryData)
+
+	names, err := afero.ReadDir(sfs, "default/config")
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+	require.Equal(t, "app.yaml", names[0].Name())
+	require.Equal(t, int64(2), names[1].Size())
+
+	b, err := afero.ReadFile(sfs, "default/config/logo.bin")
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xff, 0xd8}, b)
+
+	require.NoError(t, sfs.Rename("default/config", "default/renamed"))
+	require.NoError(t, sfs.Remove("default/renamed/app.yaml"))
+
+	l, err := afero.ReadDir(sfs, "default")
+	require.NoError(t, err)
+	require.Len(t, l, 1)
+	require.Equal(t, "renamed", l[0].Name())
+
+	_, err = cs.CoreV1().Secrets("default").Get(ctx, "cm-renamed", metav1.GetOptions{})
+	require.True(t, apierr.IsNotFound(err))
+
+	t.Run("size", func(t *testing.T) {
+		// UTF-8 values are stored as they are, not base64 encoded like in secrets
+		text := bytes.Repeat([]byte("a"), 900*1024)
+		require.NoError(t, afero.WriteFile(sfs, "default/renamed/large.txt", text, 0))
+
+		v, err := afero.ReadFile(sfs, "default/renamed/large.txt")
+		require.NoError(t, err)
+		require.Equal(t, text, v)
+
+		require.ErrorIs(t, afero.WriteFile(sfs, "default/renamed/more.txt", text[:200*1024], 0), syscall.EFBIG)
+
+		// binary values are base64 encoded
+		require.NoError(t, sfs.Remove("default/renamed/large.txt"))
+		require.ErrorIs(t, afero.WriteFile(sfs, "default/renamed/large.bin", bytes.Repeat([]byte{0xff}, 900*1024), 0), syscall.EFBIG)
+	})
+}
+
+func TestFSSecretType(t *testing.T) {
+	ctx := context.Background()
+	cs := fakeclient.New()
+	sfs := secfs.New(cs, secfs.WithSecretType(corev1.SecretTypeTLS))
+
+	t.Run("Mkdir with configured type", func(t *testing.T) {
+		err := sfs.Mkdir("default/tls", 0)
+		require.ErrorIs(t, err, secfs.ErrRequiredKey)
+		require.ErrorContains(t, err, "tls.crt")
+
+		_, err = cs.CoreV1().Secrets("default").Get(ctx, "tls", metav1.GetOptions{})
+		require.True(t, apierr.IsNotFound(err))
+
+		require.NoError(t, secfs.MkdirType(sfs, "default/opaque", corev1.SecretTypeOpaque, nil))
+	})
+
+	t.Run("MkdirType", func(t *testing.T) {
+		require.NoError(t, secfs.MkdirType(sfs, "default/tls", corev1.SecretTypeTLS, map[string][]byte{
+			corev1.TLSCertKey:       []byte("cert"),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+		}))
+
+		ks, err := cs.CoreV1().Secrets("default").Get(ctx, "tls", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, corev1.SecretTypeTLS, ks.Type)
+		require.Equal(t, map[string][]byte{
+			corev1.TLSCertKey:       []byte("cert"),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+		}, ks.Data)
+
+		require.NoError(t, afero.WriteFile(sfs, "default/tls/tls.crt", []byte("renewed"), 0))
+
+		fi, err := sfs.Stat("default/tls")
+		require.NoError(t, err)
+		require.Equal(t, corev1.SecretTypeTLS, fi.Sys().(*secfs.File).SecretType())
+	})
+
+	t.Run("MkdirType missing required key", func(t *testing.T) {
+		err := secfs.MkdirType(sfs, "default/registry", corev1.SecretTypeDockerConfigJson, map[string][]byte{
+			"config.json": []byte("{}"),
+		})
+		require.ErrorIs(t, err, secfs.ErrRequiredKey)
+
+		_, err = sfs.Stat("default/registry")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		require.ErrorIs(t, secfs.MkdirType(afero.NewMemMapFs(), "default/registry", corev1.SecretTypeOpaque, nil), syscall.ENOTSUP)
+	})
+
+	t.Run("Remove required key", func(t *testing.T) {
+		err := sfs.Remove("default/tls/tls.key")
+		require.ErrorIs(t, err, secfs.ErrRequiredKey)
+		require.ErrorContains(t, err, "tls.key")
+
+		require.ErrorIs(t, sfs.RemoveAll("default/tls/tls.crt"), secfs.ErrRequiredKey)
+
+		require.NoError(t, afero.WriteFile(sfs, "default/tls/ca.crt", []byte("ca"), 0))
+		require.NoError(t, sfs.Remove("default/tls/ca.crt"))
+	})
+
+	t.Run("Rename required key", func(t *testing.T) {
+		require.ErrorIs(t, sfs.Rename("default/tls/tls.crt", "default/tls/cert.pem"), secfs.ErrRequiredKey)
+
+		_, err := sfs.Stat("default/tls/cert.pem")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("basic-auth", func(t *testing.T) {
+		require.NoError(t, secfs.MkdirType(sfs, "default/auth", corev1.SecretTypeBasicAuth, map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte("user"),
+		}))
+
+		require.ErrorIs(t, sfs.Remove("default/auth/username"), secfs.ErrRequiredKey)
+
+		require.NoError(t, afero.WriteFile(sfs, "default/auth/password", []byte("password"), 0))
+		require.NoError(t, sfs.Remove("default/auth/username"))
+		require.ErrorIs(t, sfs.Remove("default/auth/password"), secfs.ErrRequiredKey)
+
+		require.ErrorIs(t, secfs.MkdirType(sfs, "default/noauth", corev1.SecretTypeBasicAuth, nil), secfs.ErrRequiredKey)
+	})
+
+	t.Run("Remove typed secret", func(t *testing.T) {
+		require.NoError(t, sfs.RemoveAll("default/tls"))
+
+		_, err := sfs.Stat("default/tls")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
+
+// mapBackend is a minimal backend.Backend keeping the secrets in memory
+type mapBackend struct {
+	mu      sync.Mutex
+	rv      int
+	secrets map[string]map[string]*mapSecret // by namespace and name
+}
+
+type mapSecret struct {
+	data  map[string][]byte
+	stype corev1.SecretType
+	rv    string
+	mtime time.Time
+}
+
+func newMapBackend(namespaces ...string) *mapBackend {
+	b := &mapBackend{
+		secrets: make(map[string]map[string]*mapSecret),
+	}
+
+	for _, ns := range namespaces {
+		b.secrets[ns] = make(map[string]*mapSecret)
+	}
+
+	return b
+}
+
+func (b *mapBackend) lookup(m backend.Metadata) (*mapSecret, error) {
+	ns, ok := b.secrets[m.Namespace()]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	s, ok := ns[m.Secret()]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	return s, nil
+}
+
+func (b *mapBackend) store(ns, name string, ms *mapSecret) {
+	b.rv++
+	ms.rv = fmt.Sprint(b.rv)
+	ms.mtime = time.Now()
+	b.secrets[ns][name] = ms
+}
+
+func (b *mapBackend) set(ms *mapSecret, s backend.Secret) {
+	data := make(map[string][]byte, len(ms.data))
+	for k, v := range ms.data {
+		data[k] = append([]byte{}, v...)
+	}
+
+	s.SetData(data)
+	s.SetSecretType(ms.stype)
+	s.SetResourceVersion(ms.rv)
+	s.SetTime(ms.mtime)
+}
+
+func (b *mapBackend) Create(_ context.Context, s backend.Secret) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.secrets[s.Namespace()]; !ok {
+		return syscall.ENOENT
+	}
+
+	if _, err := b.lookup(s); err == nil {
+		return syscall.EEXIST
+	}
+
+	if err := backend.ValidateData(s.SecretType(), s.Data()); err != nil {
+		return err
+	}
+
+	ms := &mapSecret{
+		data:  make(map[string][]byte),
+		stype: s.SecretType(),
+	}
+
+	for k, v := range s.Data() {
+		ms.data[k] = append([]byte{}, v...)
+	}
+
+	b.store(s.Namespace(), s.Secret(), ms)
+
+	return nil
+}
+
+func (b *mapBackend) Get(_ context.Context, s backend.Secret) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ms, err := b.lookup(s)
+	if err != nil {
+		return err
+	}
+
+	b.set(ms, s)
+
+	return nil
+}
+
+func (b *mapBackend) Update(_ context.Context, s backend.Secret) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ms, err := b.lookup(s)
+	if err != nil {
+		return err
+	}
+
+	if s.Delete() {
+		if err := backend.ValidateDelete(ms.stype, ms.data, s.Key()); err != nil {
+			return err
+		}
+
+		delete(ms.data, s.Key())
+	} else {
+		ms.data[s.Key()] = append([]byte{}, s.Value()...)
+	}
+
+	b.store(s.Namespace(), s.Secret(), ms)
+	b.set(ms, s)
+
+	return nil
+}
+
+func (b *mapBackend) Delete(_ context.Context, s backend.Secret) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.secrets[s.Namespace()], s.Secret())
+
+	return nil
+}
+
+func (b *mapBackend) Rename(_ context.Context, o, n backend.Metadata) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ms, err := b.lookup(o)
+	if err != nil {
+		return err
+	}
+
+	if _, err := b.lookup(n); err == nil {
+		return syscall.EEXIST
+	}
+
+	delete(b.secrets[o.Namespace()], o.Secret())
+	b.store(n.Namespace(), n.Secret(), ms)
+
+	return nil
+}
+
+func (b *mapBackend) Namespaces(_ context.Context) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names := []string{}
+	for ns := range b.secrets {
+		names = append(names, ns)
+	}
+
+	return names, nil
+}
+
+func (b *mapBackend) List(_ context.Context, namespace string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ns, ok := b.secrets[namespace]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	names := []string{}
+	for name := range ns {
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func TestFSNewWithBackend(t *testing.T) {
+	b := newMapBackend("default", "other")
+	sfs := secfs.NewWithBackend(b, secfs.WithSecretType(corev1.SecretTypeBasicAuth))
+
+	require.ErrorIs(t, sfs.Mkdir("default/auth", 0), secfs.ErrRequiredKey)
+	require.NoError(t, secfs.MkdirType(sfs, "default/auth", corev1.SecretTypeBasicAuth, map[string][]byte{
+		"username": []byte("user"),
+	}))
+	require.NoError(t, afero.WriteFile(sfs, "default/auth/url", []byte("https://example.com"), 0))
+
+	require.ErrorIs(t, sfs.Mkdir("default/auth", 0), fs.ErrExist)
+	require.ErrorIs(t, sfs.Remove("default/auth/username"), secfs.ErrRequiredKey)
+
+	v, err := afero.ReadFile(sfs, "default/auth/username")
+	require.NoError(t, err)
+	require.Equal(t, "user", string(v))
+
+	require.NoError(t, sfs.Rename("default/auth/url", "default/auth/endpoint"))
+	require.NoError(t, sfs.Rename("default/auth", "default/renamed"))
+
+	_, err = sfs.Stat("default/auth")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	names := []string{}
+	require.NoError(t, afero.Walk(sfs, "/", func(p string, _ fs.FileInfo, err error) error {
+		names = append(names, p)
+		return err
+	}))
+
+	require.Equal(t, []string{
+		"/",
+		"/default",
+		"/default/renamed",
+		"/default/renamed/endpoint",
+		"/default/renamed/username",
+		"/other",
+	}, names)
+
+	require.NoError(t, fstest.TestFS(secfs.NewIOFS(sfs), "default/renamed/username"))
+
+	require.NoError(t, sfs.RemoveAll("default/renamed"))
+
+	l, err := afero.ReadDir(sfs, "default")
+	require.NoError(t, err)
+	require.Empty(t, l)
+}
+
+func TestFSReadOnly(t *testing.T) {
+	cs := fakeclient.New()
+
+	require.NoError(t, secfs.New(cs).Mkdir("default/secret", 0))
+	require.NoError(t, afero.WriteFile(secfs.New(cs), "default/secret/key", []byte("value"), 0))
+
+	sfs := secfs.New(cs, secfs.WithReadOnly())
+
+	t.Run("read", func(t *testing.T) {
+		v, err := afero.ReadFile(sfs, "default/secret/key")
+		require.NoError(t, err)
+		require.Equal(t, "value", string(v))
+
+		f, err := sfs.OpenFile("default/secret/key", os.O_RDONLY, 0)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	})
+
+	t.Run("write fails before any request", func(t *testing.T) {
+		cs.ClearActions()
+
+		errs := []error{
+			sfs.Mkdir("default/new", 0),
+			sfs.MkdirAll("default/new", 0),
+			secfs.MkdirType(sfs, "default/new", corev1.SecretTypeTLS, nil),
+			sfs.Remove("default/secret/key"),
+			sfs.RemoveAll("default/secret"),
+			sfs.Rename("default/secret", "default/renamed"),
+			sfs.Chmod("default/secret/key", 0o400),
+			sfs.Chown("default/secret/key", 0, 0),
+			sfs.Chtimes("default/secret/key", time.Now(), time.Now()),
+		}
+
+		_, err := sfs.Create("default/secret/new")
+		errs = append(errs, err)
+
+		for _, flag := range []int{os.O_WRONLY, os.O_RDWR, os.O_CREATE, os.O_RDONLY | os.O_TRUNC, os.O_APPEND} {
+			_, err := sfs.OpenFile("default/secret/key", flag, 0)
+			errs = append(errs, err)
+		}
+
+		for _, err := range errs {
+			require.ErrorIs(t, err, syscall.EROFS)
+			require.ErrorIs(t, err, fs.ErrPermission)
+			require.ErrorIs(t, err, secfs.ErrReadOnly)
+		}
+
+		require.Empty(t, cs.Actions())
+	})
+
+	t.Run("write to file", func(t *testing.T) {
+		f, err := sfs.Open("default/secret/key")
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("new"))
+		require.ErrorIs(t, err, syscall.EROFS)
+
+		_, err = f.WriteString("new")
+		require.ErrorIs(t, err, syscall.EROFS)
+
+		_, err = f.WriteAt([]byte("new"), 0)
+		require.ErrorIs(t, err, syscall.EROFS)
+
+		require.ErrorIs(t, f.Truncate(0), syscall.EROFS)
+		require.NoError(t, f.Close())
+
+		v, err := afero.ReadFile(sfs, "default/secret/key")
+		require.NoError(t, err)
+		require.Equal(t, "value", string(v))
+	})
+}
+
+func TestFSChunking(t *testing.T) {
+	cs := fakeclient.New()
+	sfs := secfs.New(cs, secfs.WithChunking())
+
+	large := make([]byte, 3*backend.ChunkSize)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+
+	require.NoError(t, sfs.Mkdir("default/bundle", 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/bundle/keystore", large, 0))
+
+	t.Run("Read", func(t *testing.T) {
+		v, err := afero.ReadFile(sfs, "default/bundle/keystore")
+		require.NoError(t, err)
+		require.Equal(t, large, v)
+	})
+
+	t.Run("ReadAt and Seek across chunks", func(t *testing.T) {
+		f, err := sfs.Open("default/bundle/keystore")
+		require.NoError(t, err)
+
+		defer f.Close()
+
+		buf := make([]byte, 100)
+
+		n, err := f.ReadAt(buf, backend.ChunkSize-50)
+		require.NoError(t, err)
+		require.Equal(t, 100, n)
+		require.Equal(t, large[backend.ChunkSize-50:backend.ChunkSize+50], buf)
+
+		off, err := f.Seek(2*backend.ChunkSize-10, io.SeekStart)
+		require.NoError(t, err)
+		require.Equal(t, int64(2*backend.ChunkSize-10), off)
+
+		n, err = f.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, 100, n)
+		require.Equal(t, large[2*backend.ChunkSize-10:2*backend.ChunkSize+90], buf)
+	})
+
+	t.Run("Stat and Readdir", func(t *testing.T) {
+		fi, err := sfs.Stat("default/bundle/keystore")
+		require.NoError(t, err)
+		require.Equal(t, int64(len(large)), fi.Size())
+		require.Equal(t, int64(-1), fi.Sys().(*secfs.File).Remaining())
+
+		names, err := afero.ReadDir(sfs, "default")
+		require.NoError(t, err)
+		require.Len(t, names, 1)
+		require.Equal(t, "bundle", names[0].Name())
+	})
+
+	t.Run("Rename and Remove", func(t *testing.T) {
+		require.NoError(t, sfs.Rename("default/bundle", "default/renamed"))
+
+		v, err := afero.ReadFile(sfs, "default/renamed/keystore")
+		require.NoError(t, err)
+		require.Equal(t, large, v)
+
+		require.NoError(t, sfs.Remove("default/renamed/keystore"))
+		require.NoError(t, sfs.Remove("default/renamed"))
+
+		l, err := cs.CoreV1().Secrets("default").List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, l.Items, 1) // the secret not managed with secfs
+	})
+}
+
+func TestFSEncryption(t *testing.T) {
+	cs := fakeclient.New()
+	keys := backend.StaticKeys{
+		CurrentID: "1",
+		Keys: map[string][]byte{
+			"1": []byte("0123456789abcdef"),
+		},
+	}
+	sfs := secfs.New(cs, secfs.WithEncryption(keys))
+
+	require.NoError(t, sfs.Mkdir("default/secret", 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/key", []byte("value"), 0))
+
+	t.Run("stored encrypted", func(t *testing.T) {
+		ks, err := cs.CoreV1().Secrets("default").Get(context.Background(), "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotEqual(t, []byte("value"), ks.Data["key"])
+		require.Contains(t, ks.Annotations, backend.EncryptionKey)
+
+		v, err := afero.ReadFile(sfs, "default/secret/key")
+		require.NoError(t, err)
+		require.Equal(t, "value", string(v))
+	})
+
+	t.Run("Reencrypt", func(t *testing.T) {
+		keys.Keys["2"] = []byte("fedcba9876543210")
+		keys.CurrentID = "2"
+		rotated := secfs.New(cs, secfs.WithEncryption(keys))
+
+		require.NoError(t, secfs.Reencrypt(rotated, "default"))
+
+		ks, err := cs.CoreV1().Secrets("default").Get(context.Background(), "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"key":{"alg":"AES-GCM","kid":"2"}}`, ks.Annotations[backend.EncryptionKey])
+
+		v, err := afero.ReadFile(rotated, "default/secret/key")
+		require.NoError(t, err)
+		require.Equal(t, "value", string(v))
+
+		require.NoError(t, secfs.Reencrypt(rotated, "default/secret/key"))
+		require.ErrorIs(t, secfs.Reencrypt(rotated, "default/secret/missing"), fs.ErrNotExist)
+		require.ErrorIs(t, secfs.Reencrypt(rotated, "/"), fs.ErrPermission)
+		require.ErrorIs(t, secfs.Reencrypt(afero.NewMemMapFs(), "default/secret"), syscall.ENOTSUP)
+	})
+}
+
+func TestFSImmutable(t *testing.T) {
+	cs := fakeclient.New()
+	sfs := secfs.New(cs, secfs.WithImmutable())
+
+	require.NoError(t, secfs.MkdirType(sfs, "default/secret", corev1.SecretTypeBasicAuth, map[string][]byte{
+		"username": []byte("admin"),
+		"password": []byte("initial"),
+	}))
+
+	t.Run("Stat", func(t *testing.T) {
+		fi, err := sfs.Stat("default/secret/username")
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o400), fi.Mode())
+
+		entries, err := afero.ReadDir(sfs, "default/secret")
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, os.FileMode(0o400), entries[0].Mode())
+	})
+
+	t.Run("writes fail", func(t *testing.T) {
+		_, err := sfs.OpenFile("default/secret/username", os.O_WRONLY, 0)
+		require.ErrorIs(t, err, syscall.EPERM)
+
+		_, err = sfs.Create("default/secret/new")
+		require.ErrorIs(t, err, fs.ErrPermission)
+
+		require.ErrorIs(t, sfs.Remove("default/secret/username"), syscall.EPERM)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		require.NoError(t, secfs.Replace(sfs, "default/secret", map[string][]byte{
+			"username": []byte("user"),
+			"password": []byte("secret"),
+		}))
+
+		v, err := afero.ReadFile(sfs, "default/secret/password")
+		require.NoError(t, err)
+		require.Equal(t, "secret", string(v))
+
+		fi, err := sfs.Stat("default/secret/password")
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o400), fi.Mode())
+
+		err = secfs.Replace(sfs, "default/secret", map[string][]byte{
+			"url": []byte("https://example.com"),
+		})
+		require.ErrorIs(t, err, secfs.ErrRequiredKey)
+
+		require.ErrorIs(t, secfs.Replace(sfs, "default/secret/password", nil), syscall.ENOTDIR)
+		require.ErrorIs(t, secfs.Replace(sfs, "default", nil), syscall.EPERM)
+		require.ErrorIs(t, secfs.Replace(sfs, "default/missing", nil), fs.ErrNotExist)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		require.NoError(t, sfs.RemoveAll("default/secret"))
+	})
+}
+
+func TestFSHistory(t *testing.T) {
+	cs := fakeclient.New()
+	sfs := secfs.New(cs, secfs.WithHistory(10, 0))
+
+	require.NoError(t, sfs.Mkdir("default/secret", 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/key", []byte("v1"), 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/key", []byte("v2"), 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/other", []byte("v1"), 0))
+
+	t.Run("Revisions", func(t *testing.T) {
+		revisions, err := secfs.Revisions(sfs, "default/secret")
+		require.NoError(t, err)
+		// the keys are created empty before they are written
+		require.Len(t, revisions, 5)
+
+		revisions, err = secfs.Revisions(sfs, "default/secret/key")
+		require.NoError(t, err)
+		require.Len(t, revisions, 4)
+		require.Equal(t, 2, revisions[0].ID)
+
+		_, err = secfs.Revisions(sfs, "default")
+		require.ErrorIs(t, err, syscall.EPERM)
+
+		_, err = secfs.Revisions(afero.NewMemMapFs(), "default/secret")
+		require.ErrorIs(t, err, syscall.ENOTSUP)
+	})
+
+	t.Run("OpenRevision", func(t *testing.T) {
+		f, err := secfs.OpenRevision(sfs, "default/secret/key", 3)
+		require.NoError(t, err)
+
+		v, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, "v1", string(v))
+
+		_, err = f.Write([]byte("v3"))
+		require.ErrorIs(t, err, secfs.ErrReadOnly)
+
+		d, err := secfs.OpenRevision(sfs, "default/secret", 1)
+		require.NoError(t, err)
+
+		names, err := d.Readdirnames(-1)
+		require.NoError(t, err)
+		require.Empty(t, names)
+
+		_, err = secfs.OpenRevision(sfs, "default/secret/other", 2)
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		changes, err := secfs.Diff(sfs, "default/secret", 3, backend.CurrentRevision)
+		require.NoError(t, err)
+		require.Equal(t, []backend.Change{
+			{Key: "key", Type: backend.Modified},
+			{Key: "other", Type: backend.Added},
+		}, changes)
+
+		changes, err = secfs.Diff(sfs, "default/secret/other", 4, backend.CurrentRevision)
+		require.NoError(t, err)
+		require.Equal(t, []backend.Change{{Key: "other", Type: backend.Added}}, changes)
+	})
+
+	t.Run("Restore key", func(t *testing.T) {
+		require.NoError(t, secfs.Restore(sfs, "default/secret/key", 3))
+
+		v, err := afero.ReadFile(sfs, "default/secret/key")
+		require.NoError(t, err)
+		require.Equal(t, "v1", string(v))
+	})
+
+	t.Run("Restore secret", func(t *testing.T) {
+		require.NoError(t, secfs.Restore(sfs, "default/secret", 4))
+
+		names, err := afero.ReadDir(sfs, "default/secret")
+		require.NoError(t, err)
+		require.Len(t, names, 1)
+
+		v, err := afero.ReadFile(sfs, "default/secret/key")
+		require.NoError(t, err)
+		require.Equal(t, "v2", string(v))
+
+		revisions, err := secfs.Revisions(sfs, "default/secret")
+		require.NoError(t, err)
+		require.Len(t, revisions, 7)
+	})
+
+	t.Run("hidden", func(t *testing.T) {
+		names, err := afero.ReadDir(sfs, "default")
+		require.NoError(t, err)
+		require.Len(t, names, 1)
+	})
+}
+
+func TestFSWatch(t *testing.T) {
+	cs := fakeclient.New()
+	sfs := secfs.New(cs)
+
+	require.NoError(t, sfs.Mkdir("default/secret", 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/key", []byte("v1"), 0))
+
+	// next returns the next event with a timeout
+	next := func(t *testing.T, w *secfs.Watcher) secfs.Event {
+		select {
+		case e := <-w.Events:
+			return e
+		case err := <-w.Errors:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "no event")
+		}
+
+		return secfs.Event{}
+	}
+
+	t.Run("key", func(t *testing.T) {
+		w, err := secfs.Watch(sfs, "default/secret/key")
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, afero.WriteFile(sfs, "default/secret/other", []byte("v1"), 0))
+		require.NoError(t, afero.WriteFile(sfs, "default/secret/key", []byte("v2"), 0))
+		require.Equal(t, secfs.Event{Name: "default/secret/key", Op: secfs.Write}, next(t, w))
+
+		require.NoError(t, sfs.Remove("default/secret/key"))
+		require.Equal(t, secfs.Event{Name: "default/secret/key", Op: secfs.Remove}, next(t, w))
+		require.NoError(t, sfs.Remove("default/secret/other"))
+	})
+
+	t.Run("namespace", func(t *testing.T) {
+		w, err := secfs.Watch(sfs, "default")
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, sfs.Mkdir("default/new", 0))
+		require.Equal(t, secfs.Event{Name: "default/new", Op: secfs.Create}, next(t, w))
+
+		f, err := sfs.Create("default/new/key")
+		require.NoError(t, err)
+		require.Equal(t, secfs.Event{Name: "default/new/key", Op: secfs.Create}, next(t, w))
+
+		_, err = f.WriteString("value")
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.Equal(t, secfs.Event{Name: "default/new/key", Op: secfs.Write}, next(t, w))
+
+		require.NoError(t, sfs.RemoveAll("default/new"))
+
+		e := next(t, w)
+		require.Equal(t, "default/new/key", e.Name)
+		require.True(t, e.Has(secfs.Remove))
+		require.Equal(t, secfs.Event{Name: "default/new", Op: secfs.Remove}, next(t, w))
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		require.NoError(t, afero.WriteFile(sfs, "default/secret/old", []byte("value"), 0))
+
+		w, err := secfs.Watch(sfs, "default/secret")
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		require.NoError(t, sfs.Rename("default/secret/old", "default/secret/new"))
+
+		require.Equal(t, secfs.Event{Name: "default/secret/new", Op: secfs.Create}, next(t, w))
+		require.Equal(t, secfs.Event{Name: "default/secret/old", Op: secfs.Rename}, next(t, w))
+
+		// other writers renaming a key with a single change
+		ks, err := cs.CoreV1().Secrets("default").Get(context.Background(), "secret", metav1.GetOptions{})
+		require.NoError(t, err)
+
+		ks.Data["newer"] = ks.Data["new"]
+		delete(ks.Data, "new")
+
+		_, err = cs.CoreV1().Secrets("default").Update(context.Background(), ks, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		require.Equal(t, secfs.Event{Name: "default/secret/new", Op: secfs.Rename}, next(t, w))
+		require.Equal(t, secfs.Event{Name: "default/secret/newer", Op: secfs.Create}, next(t, w))
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := secfs.Watch(afero.NewMemMapFs(), "default")
+		require.ErrorIs(t, err, syscall.ENOTSUP)
+	})
+
+	t.Run("String", func(t *testing.T) {
+		require.Equal(t, `"default/secret/key": CREATE|WRITE`, secfs.Event{Name: "default/secret/key", Op: secfs.Create | secfs.Write}.String())
+	})
+}
+
+func TestFSReloader(t *testing.T) {
+	sfs := secfs.New(fakeclient.New())
+
+	require.NoError(t, sfs.Mkdir("default/secret", 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/port", []byte("80"), 0))
+
+	decode := func(b []byte) (int, error) {
+		var port int
+		_, err := fmt.Sscanf(string(b), "%d", &port)
+		return port, err
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		r, err := secfs.NewReloader(sfs, "default/secret/port", decode, 200*time.Millisecond)
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		require.Equal(t, 80, r.Load())
+
+		changes := make(chan int, 10)
+		errs := make(chan error, 10)
+
+		r.OnChange(func(v int) { changes <- v })
+		r.OnError(func(err error) { errs <- err })
+
+		// the writes within the debounce delay are reloaded once
+		for _, v := range []string{"8080", "8081", "8443"} {
+			require.NoError(t, afero.WriteFile(sfs, "default/secret/port", []byte(v), 0))
+		}
+
+		select {
+		case v := <-changes:
+			require.Equal(t, 8443, v)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "no change")
+		}
+
+		require.Equal(t, 8443, r.Load())
+
+		// the last good value is kept
+		require.NoError(t, afero.WriteFile(sfs, "default/secret/port", []byte("invalid"), 0))
+
+		select {
+		case err := <-errs:
+			require.Error(t, err)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "no error")
+		}
+
+		require.Equal(t, 8443, r.Load())
+		require.Empty(t, changes)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := secfs.NewReloader(sfs, "default/secret/port", decode, 0)
+		require.Error(t, err)
+
+		_, err = secfs.NewReloader(sfs, "default/secret/missing", decode, 0)
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		_, err = secfs.NewReloader(sfs, "default/secret", decode, 0)
+		require.ErrorIs(t, err, syscall.EISDIR)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := secfs.NewReloader(afero.NewMemMapFs(), "default/secret/port", decode, 0)
+		require.ErrorIs(t, err, syscall.ENOTSUP)
+	})
+}
+
+func TestFSAttributes(t *testing.T) {
+	sfs := secfs.New(fakeclient.New())
+
+	require.NoError(t, sfs.Mkdir("default/secret", 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/key", []byte("value"), 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/other", []byte("value"), 0))
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	atime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("Chmod", func(t *testing.T) {
+		require.NoError(t, sfs.Chmod("default/secret/key", 0o400))
+
+		fi, err := sfs.Stat("default/secret/key")
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o400), fi.Mode())
+
+		_, err = sfs.OpenFile("default/secret/key", os.O_WRONLY, 0)
+		require.ErrorIs(t, err, syscall.EACCES)
+
+		_, err = sfs.Create("default/secret/key")
+		require.ErrorIs(t, err, syscall.EACCES)
+
+		// the other keys keep the default mode
+		fi, err = sfs.Stat("default/secret/other")
+		require.NoError(t, err)
+		require.Equal(t, secfs.DefaultFileMode, fi.Mode())
+
+		require.NoError(t, sfs.Chmod("default/secret", 0o750))
+
+		fi, err = sfs.Stat("default/secret")
+		require.NoError(t, err)
+		require.Equal(t, os.ModeDir|0o750, fi.Mode())
+
+		require.NoError(t, sfs.Chmod("default/secret/key", 0o640))
+		require.NoError(t, afero.WriteFile(sfs, "default/secret/key", []byte("new"), 0))
+	})
+
+	t.Run("Chown", func(t *testing.T) {
+		require.NoError(t, sfs.Chown("default/secret/key", 1000, 2000))
+		require.NoError(t, sfs.Chown("default/secret/key", -1, 3000))
+
+		fi, err := sfs.Stat("default/secret/key")
+		require.NoError(t, err)
+
+		a := fi.Sys().(*secfs.File).Attributes()
+		require.Equal(t, 1000, *a.UID)
+		require.Equal(t, 3000, *a.GID)
+		require.Equal(t, os.FileMode(0o640), fi.Mode())
+	})
+
+	t.Run("Chtimes", func(t *testing.T) {
+		require.NoError(t, sfs.Chtimes("default/secret/key", atime, mtime))
+
+		fi, err := sfs.Stat("default/secret/key")
+		require.NoError(t, err)
+		require.True(t, mtime.Equal(fi.ModTime()))
+		require.True(t, atime.Equal(*fi.Sys().(*secfs.File).Attributes().Atime))
+
+		// the entries of the secret report the attributes as well
+		entries, err := afero.ReadDir(sfs, "default/secret")
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.True(t, mtime.Equal(entries[0].ModTime()))
+		require.Equal(t, os.FileMode(0o640), entries[0].Mode())
+
+		// the modification time is reset by a write
+		require.NoError(t, afero.WriteFile(sfs, "default/secret/key", []byte("newer"), 0))
+
+		fi, err = sfs.Stat("default/secret/key")
+		require.NoError(t, err)
+		require.False(t, mtime.Equal(fi.ModTime()))
+		require.True(t, atime.Equal(*fi.Sys().(*secfs.File).Attributes().Atime))
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		require.NoError(t, sfs.Rename("default/secret/key", "default/secret/renamed"))
+
+		fi, err := sfs.Stat("default/secret/renamed")
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o640), fi.Mode())
+		require.Equal(t, 1000, *fi.Sys().(*secfs.File).Attributes().UID)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		require.ErrorIs(t, sfs.Chmod("default/secret/missing", 0o400), fs.ErrNotExist)
+		require.ErrorIs(t, sfs.Chmod("default", 0o700), syscall.EPERM)
+	})
+}
+
+func TestFSModTimes(t *testing.T) {
+	sfs := secfs.New(fakeclient.New())
+
+	require.NoError(t, sfs.Mkdir("default/secret", 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/key1", []byte("value1"), 0))
+	require.NoError(t, afero.WriteFile(sfs, "default/secret/key2", []byte("value2"), 0))
+
+	t1 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	t2 := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	require.NoError(t, sfs.Chtimes("default/secret/key1", time.Time{}, t1))
+	require.NoError(t, sfs.Chtimes("default/secret/key2", time.Time{}, t2))
+
+	modTime := func(t *testing.T, name string) time.Time {
+		fi, err := sfs.Stat(name)
+		require.NoError(t, err)
+
+		return fi.ModTime()
+	}
+
+	t.Run("per key", func(t *testing.T) {
+		require.True(t, t1.Equal(modTime(t, "default/secret/key1")))
+		require.True(t, t2.Equal(modTime(t, "default/secret/key2")))
+		require.True(t, t2.Equal(modTime(t, "default/secret")))
+
+		entries, err := afero.ReadDir(sfs, "default/secret")
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.True(t, t1.Equal(entries[0].ModTime()))
+		require.True(t, t2.Equal(entries[1].ModTime()))
+	})
+
+	t.Run("write", func(t *testing.T) {
+		require.NoError(t, afero.WriteFile(sfs, "default/secret/key1", []byte("updated"), 0))
+
+		// the other key is not modified
+		require.True(t, t2.Equal(modTime(t, "default/secret/key2")))
+		require.True(t, modTime(t, "default/secret/key1").After(t2))
+		require.Equal(t, modTime(t, "default/secret/key1"), modTime(t, "default/secret"))
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		require.NoError(t, sfs.Chtimes("default/secret/key1", time.Time{}, t1))
+		require.NoError(t, sfs.Remove("default/secret/key1"))
+
+		// the removal modifies the secret
+		require.True(t, modTime(t, "default/secret").After(t2))
+	})
+}
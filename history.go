@@ -0,0 +1,168 @@
+package secfs
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/postfinance/secfs/backend"
+	"github.com/spf13/afero"
+)
+
+// Revisions returns the revisions of the secret name ordered by ID, for a key only the revisions containing the key.
+// The previous data of the secrets is recorded with WithHistory.
+// If fsys is not a secfs or its backend does not implement backend.Historian ENOTSUP is returned.
+func Revisions(fsys afero.Fs, name string) ([]backend.Revision, error) {
+	sfs, h, sp, err := historian(fsys, "Revisions", name)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := h.Revisions(sfs.ctx, sp)
+	if err != nil {
+		return nil, wrapPathError("Revisions", name, err)
+	}
+
+	return revisions, nil
+}
+
+// OpenRevision opens the secret or key name as it has been in revision id, backend.CurrentRevision opens the current data.
+// The returned file is read-only.
+// If fsys is not a secfs or its backend does not implement backend.Historian ENOTSUP is returned.
+func OpenRevision(fsys afero.Fs, name string, id int) (afero.File, error) {
+	sfs, h, _, err := historian(fsys, "OpenRevision", name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := sfs.openRevision(h, name, id)
+	if err != nil {
+		return nil, wrapPathError("OpenRevision", name, err)
+	}
+
+	return f, nil
+}
+
+// Diff returns the changes of the keys of the secret name from revision from to revision to ordered by key,
+// for a key only the change of the key. backend.CurrentRevision refers to the current data.
+// If fsys is not a secfs or its backend does not implement backend.Historian ENOTSUP is returned.
+func Diff(fsys afero.Fs, name string, from, to int) ([]backend.Change, error) {
+	sfs, h, sp, err := historian(fsys, "Diff", name)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := path.Join(sp.Namespace(), sp.Secret())
+
+	a, err := sfs.openRevision(h, dir, from)
+	if err != nil {
+		return nil, wrapPathError("Diff", name, err)
+	}
+
+	b, err := sfs.openRevision(h, dir, to)
+	if err != nil {
+		return nil, wrapPathError("Diff", name, err)
+	}
+
+	changes := []backend.Change{}
+
+	for _, c := range backend.Diff(a.data, b.data) {
+		if sp.IsDir() || c.Key == sp.Key() {
+			changes = append(changes, c)
+		}
+	}
+
+	return changes, nil
+}
+
+// Restore restores the secret or key name to revision id.
+// A key is written with the value of the revision like any other write. A secret is replaced
+// with the data of the revision (see Replace), keys added since the revision are removed.
+// The current data is recorded as a new revision before it is overwritten.
+// If fsys is not a secfs or its backend does not implement backend.Historian ENOTSUP is returned.
+func Restore(fsys afero.Fs, name string, id int) error {
+	sfs, h, sp, err := historian(fsys, "Restore", name)
+	if err != nil {
+		return err
+	}
+
+	if err := sfs.writable("Restore", name); err != nil {
+		return err
+	}
+
+	rev, err := sfs.openRevision(h, name, id)
+	if err != nil {
+		return wrapPathError("Restore", name, err)
+	}
+
+	if sp.IsDir() {
+		return sfs.replace(name, rev.data)
+	}
+
+	f, err := sfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(rev.value); err != nil {
+		_ = f.Close()
+		return wrapPathError("Restore", name, err)
+	}
+
+	return f.Close()
+}
+
+// historian returns the secfs and its backend.Historian for the secret or key name
+func historian(fsys afero.Fs, op, name string) (*secfs, backend.Historian, *secretPath, error) {
+	sfs, ok := fsys.(*secfs)
+	if !ok {
+		return nil, nil, nil, wrapPathError(op, name, syscall.ENOTSUP)
+	}
+
+	h, ok := sfs.backend.(backend.Historian)
+	if !ok {
+		return nil, nil, nil, wrapPathError(op, name, syscall.ENOTSUP)
+	}
+
+	sp, err := newSecretPath(name)
+	if err != nil {
+		return nil, nil, nil, wrapPathError(op, name, err)
+	}
+
+	// namespaces are not managed with secfs
+	if sp.IsVirtual() {
+		return nil, nil, nil, wrapPathError(op, name, syscall.EPERM)
+	}
+
+	return sfs, h, sp, nil
+}
+
+// openRevision returns the read-only file of the secret or key name in revision id
+func (sfs secfs) openRevision(h backend.Historian, name string, id int) (*File, error) {
+	f, err := newFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.backend = sfs.backend
+	f.ctx = sfs.ctx
+	f.rofs = true
+
+	if err := h.GetRevision(sfs.ctx, f, id); err != nil {
+		return nil, err
+	}
+
+	if f.IsDir() {
+		return f, nil
+	}
+
+	v, ok := f.data[f.key]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	f.value = bytes.Clone(v)
+
+	return f, nil
+}
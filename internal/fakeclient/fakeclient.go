@@ -0,0 +1,147 @@
+// Package fakeclient provides the fake clientset for the tests of secfs, its backends and secfstest
+package fakeclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Constants for testing with fake backend
+const (
+	Prefix = "unit-"
+	Suffix = "-test"
+)
+
+// New returns a fake clientset for testing
+// The resourceVersion of secrets and config maps is maintained like the API server does,
+// updates with an outdated resourceVersion fail with a conflict.
+func New() *fake.Clientset {
+	cs := fake.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+		},
+	}, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            fmt.Sprintf("%snotmanaged%s", Prefix, Suffix),
+			Namespace:       "default",
+			ResourceVersion: "1",
+			Annotations: map[string]string{
+				"testfile": "",
+			},
+		},
+	})
+
+	rv := &resourceVersions{
+		tracker: cs.Tracker(),
+		last:    1,
+	}
+
+	for _, resource := range []string{"secrets", "configmaps"} {
+		cs.PrependReactor("create", resource, rv.create)
+		cs.PrependReactor("update", resource, rv.update)
+		cs.PrependReactor("patch", resource, rv.patch)
+	}
+
+	return cs
+}
+
+// resourceVersions emulates the resourceVersion handling of the API server for the fake clientset
+// the reactors are called with the lock of the fake clientset held
+type resourceVersions struct {
+	tracker k8stesting.ObjectTracker
+	last    int
+}
+
+func (r *resourceVersions) next() string {
+	r.last++
+
+	return strconv.Itoa(r.last)
+}
+
+func (r *resourceVersions) create(a k8stesting.Action) (bool, runtime.Object, error) {
+	obj := a.(k8stesting.CreateAction).GetObject().DeepCopyObject()
+	m := obj.(metav1.Object)
+
+	if m.GetResourceVersion() != "" {
+		return true, nil, apierr.NewBadRequest("resourceVersion should not be set on objects to be created")
+	}
+
+	m.SetResourceVersion(r.next())
+
+	if err := r.tracker.Create(a.GetResource(), obj, a.GetNamespace()); err != nil {
+		return true, nil, err
+	}
+
+	return true, obj, nil
+}
+
+func (r *resourceVersions) update(a k8stesting.Action) (bool, runtime.Object, error) {
+	obj := a.(k8stesting.UpdateAction).GetObject().DeepCopyObject()
+	m := obj.(metav1.Object)
+
+	cur, err := r.tracker.Get(a.GetResource(), a.GetNamespace(), m.GetName())
+	if err != nil {
+		return true, nil, err
+	}
+
+	if m.GetResourceVersion() != "" && m.GetResourceVersion() != cur.(metav1.Object).GetResourceVersion() {
+		return true, nil, r.conflict(a, m.GetName())
+	}
+
+	m.SetResourceVersion(r.next())
+
+	if err := r.tracker.Update(a.GetResource(), obj, a.GetNamespace()); err != nil {
+		return true, nil, err
+	}
+
+	return true, obj, nil
+}
+
+func (r *resourceVersions) patch(a k8stesting.Action) (bool, runtime.Object, error) {
+	pa := a.(k8stesting.PatchAction)
+
+	cur, err := r.tracker.Get(a.GetResource(), a.GetNamespace(), pa.GetName())
+	if err != nil {
+		return true, nil, err
+	}
+
+	// the resourceVersion of a merge patch is a precondition
+	if pa.GetPatchType() == types.MergePatchType {
+		p := metav1.PartialObjectMetadata{}
+		if err := json.Unmarshal(pa.GetPatch(), &p); err != nil {
+			return true, nil, apierr.NewBadRequest(err.Error())
+		}
+
+		if p.ResourceVersion != "" && p.ResourceVersion != cur.(metav1.Object).GetResourceVersion() {
+			return true, nil, r.conflict(a, pa.GetName())
+		}
+	}
+
+	_, obj, err := k8stesting.ObjectReaction(r.tracker)(a)
+	if err != nil {
+		return true, nil, err
+	}
+
+	obj.(metav1.Object).SetResourceVersion(r.next())
+
+	if err := r.tracker.Update(a.GetResource(), obj, a.GetNamespace()); err != nil {
+		return true, nil, err
+	}
+
+	return true, obj, nil
+}
+
+func (r *resourceVersions) conflict(a k8stesting.Action, name string) error {
+	return apierr.NewConflict(a.GetResource().GroupResource(), name,
+		errors.New("the object has been modified; please apply your changes to the latest version and try again"))
+}
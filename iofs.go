@@ -0,0 +1,121 @@
+package secfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// ioFS implements the io/fs interfaces for a secfs
+// The names are slash separated and unrooted like "namespace/secret/key", "." is the root.
+type ioFS struct {
+	sfs *secfs
+	dir string // directory of a sub filesystem, empty for the root
+}
+
+var (
+	_ fs.FS         = (*ioFS)(nil) // https://pkg.go.dev/io/fs#FS
+	_ fs.StatFS     = (*ioFS)(nil) // https://pkg.go.dev/io/fs#StatFS
+	_ fs.ReadDirFS  = (*ioFS)(nil) // https://pkg.go.dev/io/fs#ReadDirFS
+	_ fs.ReadFileFS = (*ioFS)(nil) // https://pkg.go.dev/io/fs#ReadFileFS
+	_ fs.SubFS      = (*ioFS)(nil) // https://pkg.go.dev/io/fs#SubFS
+)
+
+// NewIOFS returns fsys as io/fs filesystem, e.g. for template.ParseFS, http.FS or fs.WalkDir.
+// The requests use the context of fsys (see WithContext).
+// If fsys is not a secfs the generic afero.IOFS adapter is returned.
+func NewIOFS(fsys afero.Fs) fs.FS {
+	s, ok := fsys.(*secfs)
+	if !ok {
+		return afero.NewIOFS(fsys)
+	}
+
+	return &ioFS{
+		sfs: s,
+	}
+}
+
+// Open opens the named file or directory (fs.FS)
+func (f *ioFS) Open(name string) (fs.File, error) {
+	file, err := f.open("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Stat returns a FileInfo describing the named file or directory (fs.StatFS)
+func (f *ioFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.open("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name (fs.ReadDirFS)
+func (f *ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := f.open("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := file.ReadDir(-1)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	return entries, nil
+}
+
+// ReadFile reads the named key and returns a copy of its value (fs.ReadFileFS)
+func (f *ioFS) ReadFile(name string) ([]byte, error) {
+	file, err := f.open("read", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+
+	return bytes.Clone(file.Value()), nil
+}
+
+// Sub returns the filesystem rooted at dir (fs.SubFS)
+func (f *ioFS) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+
+	return &ioFS{
+		sfs: f.sfs,
+		dir: path.Join(f.dir, dir),
+	}, nil
+}
+
+// open opens name relative to the directory of f
+// the errors carry the io/fs name instead of the name in the secfs
+func (f *ioFS) open(op, name string) (*File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	file, err := OpenContext(f.sfs.ctx, f.sfs.backend, path.Join(f.dir, name))
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	return file, nil
+}
@@ -0,0 +1,85 @@
+package secfs_test
+
+import (
+	"io/fs"
+	"path"
+	"testing"
+	"testing/fstest"
+
+	"github.com/postfinance/secfs"
+	"github.com/postfinance/secfs/internal/fakeclient"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIOFS(t *testing.T) {
+	namespace := "default"
+
+	values := map[string]string{
+		"testsecret1/testfile1": "value1",
+		"testsecret1/testfile2": "",
+		"testsecret2/tls.crt":   "certificate",
+		"testsecret2/tls.key":   "private key",
+	}
+
+	sfs := secfs.New(fakeclient.New())
+
+	for name, value := range values {
+		require.NoError(t, sfs.MkdirAll(path.Dir(path.Join(namespace, name)), 0))
+		require.NoError(t, afero.WriteFile(sfs, path.Join(namespace, name), []byte(value), 0))
+	}
+
+	fsys := secfs.NewIOFS(sfs)
+
+	t.Run("TestFS", func(t *testing.T) {
+		expected := []string{}
+		for name := range values {
+			expected = append(expected, path.Join(namespace, name))
+		}
+
+		require.NoError(t, fstest.TestFS(fsys, expected...))
+	})
+
+	t.Run("TestFS sub", func(t *testing.T) {
+		sub, err := fs.Sub(fsys, path.Join(namespace, "testsecret2"))
+		require.NoError(t, err)
+
+		require.NoError(t, fstest.TestFS(sub, "tls.crt", "tls.key"))
+	})
+
+	t.Run("ReadFile", func(t *testing.T) {
+		for name, value := range values {
+			b, err := fs.ReadFile(fsys, path.Join(namespace, name))
+			require.NoError(t, err)
+			require.Equal(t, value, string(b))
+		}
+
+		_, err := fs.ReadFile(fsys, path.Join(namespace, "testsecret1"))
+		require.Error(t, err)
+	})
+
+	t.Run("Glob", func(t *testing.T) {
+		matches, err := fs.Glob(fsys, "*/*/tls.*")
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			path.Join(namespace, "testsecret2", "tls.crt"),
+			path.Join(namespace, "testsecret2", "tls.key"),
+		}, matches)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := fsys.Open("/default")
+		require.ErrorIs(t, err, fs.ErrInvalid)
+
+		_, err = fs.Stat(fsys, path.Join(namespace, "notexist"))
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		var pe *fs.PathError
+		require.ErrorAs(t, err, &pe)
+		require.Equal(t, path.Join(namespace, "notexist"), pe.Path)
+	})
+
+	t.Run("Not a secfs", func(t *testing.T) {
+		require.IsType(t, afero.IOFS{}, secfs.NewIOFS(afero.NewMemMapFs()))
+	})
+}
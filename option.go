@@ -1,7 +1,11 @@
 package secfs
 
 import (
+	"context"
 	"time"
+
+	"github.com/postfinance/secfs/backend"
+	corev1 "k8s.io/api/core/v1"
 )
 
 // Option represents a functional Option
@@ -28,9 +32,93 @@ func WithSecretLabels(labels map[string]string) Option {
 	}
 }
 
+// WithSecretType configures the type of the secrets created with Mkdir (default Opaque).
+// Mkdir creates empty secrets, it fails with ErrRequiredKey for types requiring keys, e.g. TLS secrets,
+// create them with MkdirType instead. The required keys can not be removed afterwards.
+// The type does not apply to config maps.
+func WithSecretType(t corev1.SecretType) Option {
+	return func(s *secfs) {
+		s.secretType = t
+	}
+}
+
+// WithReadOnly configures a read-only filesystem, all operations modifying secrets fail
+// with ErrReadOnly before any request is sent. A read-only filesystem only requires
+// the permissions to get, list and watch secrets.
+func WithReadOnly() Option {
+	return func(s *secfs) {
+		s.readonly = true
+	}
+}
+
+// WithChunking configures values which do not fit into a secret to be stored in several
+// chunk secrets linked with annotations, reads stitch the chunks transparently.
+// The chunk secrets are hidden in the directory listings, they are removed and renamed with their secret.
+func WithChunking() Option {
+	return func(s *secfs) {
+		s.chunking = true
+	}
+}
+
+// WithImmutable configures the secrets created with Mkdir to be immutable.
+// Keys of immutable secrets are reported read-only by Stat, writes fail with EPERM before any
+// request is sent. The data of immutable secrets can be changed with Replace.
+func WithImmutable() Option {
+	return func(s *secfs) {
+		s.immutable = true
+	}
+}
+
+// WithHistory configures the previous data of a secret to be recorded as a revision before it is changed.
+// The revisions are stored in a history secret next to the secret, hidden in the directory listings.
+// The oldest revisions are removed if there are more than revisions or the history secret is larger than
+// size bytes (at most backend.MaxSecretSize, also if size is not positive).
+// See Revisions, OpenRevision, Diff and Restore.
+func WithHistory(revisions, size int) Option {
+	return func(s *secfs) {
+		s.historyRevisions = revisions
+		s.historySize = size
+	}
+}
+
+// WithEncryption configures client-side encryption of the values with AES-GCM, the keys are provided by kp.
+// The algorithm and the key ID of every encrypted key are stored in the annotation encryption of the secret.
+// Written keys are encrypted with the current key, values encrypted with other keys known to kp are still
+// decrypted, so keys can be rotated lazily or with Reencrypt. Typed secrets whose keys are validated by the
+// API server (.dockerconfigjson and .dockercfg) can not be written with encryption, such writes fail with EINVAL.
+func WithEncryption(kp backend.KeyProvider) Option {
+	return func(s *secfs) {
+		s.keys = kp
+	}
+}
+
 // WithTimeout configures a custom request timeout
 func WithTimeout(t time.Duration) Option {
 	return func(s *secfs) {
 		s.timeout = t
 	}
 }
+
+// WithRetry configures retries with exponential backoff for idempotent requests failing with
+// transient errors like 429, 503, timeouts or connection resets.
+// maxAttempts includes the first attempt, backoff is the initial delay which doubles after every attempt.
+// A delay suggested by the API server with Retry-After takes precedence.
+func WithRetry(maxAttempts int, backoff time.Duration) Option {
+	return func(s *secfs) {
+		s.retryAttempts = maxAttempts
+		s.retryBackoff = backoff
+	}
+}
+
+// WithCache configures an informer based read cache for Stat, Open and Readdir, the informers run until ctx is done.
+// The cache is scoped to the secrets with the labels in selector and to namespaces (all namespaces if empty),
+// secrets not found in the cache are read from the API server. Directories are only listed from the cache if
+// the labels of WithSecretLabels include the labels in selector. Writes go to the API server, the cache is updated
+// from watch events. The cache requires the permission to list and watch secrets.
+func WithCache(ctx context.Context, selector map[string]string, namespaces ...string) Option {
+	return func(s *secfs) {
+		s.cacheCtx = ctx
+		s.cacheSelector = selector
+		s.cacheNamespaces = namespaces
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"strings"
 	"syscall"
 
-	"github.com/postfinance/secfs/internal/backend"
+	"github.com/postfinance/secfs/backend"
 )
 
 type secretPath struct {
@@ -16,21 +16,33 @@ type secretPath struct {
 }
 
 // newSecretPath returns the secretPath for name
+// /                      -> root, lists namespaces
+// /NAMESPACE             -> namespace, lists secrets
+// /NAMESPACE/SECRET      -> secret, lists keys
+// /NAMESPACE/SECRET/KEY  -> key
 func newSecretPath(name string) (*secretPath, error) {
-	parts := strings.Split(strings.Trim(name, "/"), "/")
-	if len(parts) < 2 || len(parts) > 3 {
+	name = strings.Trim(name, "/")
+	if name == "" || name == "." {
+		return &secretPath{isDir: true}, nil
+	}
+
+	parts := strings.Split(name, "/")
+	if len(parts) > 3 {
 		return nil, syscall.EINVAL
 	}
 
 	p := &secretPath{
 		namespace: parts[0],
-		secret:    parts[1],
 	}
 
 	switch len(parts) {
+	case 1:
+		p.isDir = true
 	case 2:
+		p.secret = parts[1]
 		p.isDir = true
 	case 3:
+		p.secret = parts[1]
 		p.key = parts[2]
 	}
 
@@ -38,14 +50,23 @@ func newSecretPath(name string) (*secretPath, error) {
 }
 
 func (p secretPath) Name() string {
-	if p.key != "" {
+	switch {
+	case p.key != "":
 		return p.key
+	case p.secret != "":
+		return p.secret
+	case p.namespace != "":
+		return p.namespace
+	default:
+		return "/"
 	}
-
-	return p.secret
 }
 
 func (p secretPath) Absolute() string {
+	if p.IsRoot() {
+		return "/"
+	}
+
 	return path.Join(p.namespace, p.secret, p.key)
 }
 
@@ -53,6 +74,21 @@ func (p secretPath) IsDir() bool {
 	return p.isDir
 }
 
+// IsRoot returns true for the root directory containing the namespaces
+func (p secretPath) IsRoot() bool {
+	return p.namespace == ""
+}
+
+// IsNamespace returns true for a namespace directory containing the secrets
+func (p secretPath) IsNamespace() bool {
+	return p.namespace != "" && p.secret == ""
+}
+
+// IsVirtual returns true for the root and namespace directories, which are not backed by a secret
+func (p secretPath) IsVirtual() bool {
+	return p.IsRoot() || p.IsNamespace()
+}
+
 var _ backend.Metadata = secretPath{}
 
 func (p secretPath) Namespace() string {
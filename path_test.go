@@ -10,10 +10,6 @@ import (
 func TestPath(t *testing.T) {
 	t.Run("invalid path", func(t *testing.T) {
 		invalid := []string{
-			"default",
-			"/default",
-			"/default/",
-			"default/",
 			"default/secret/key/more",
 			"default/secret/key/more/",
 			"/default/secret/key/more/",
@@ -47,6 +43,47 @@ func TestPath(t *testing.T) {
 		}
 	})
 
+	t.Run("valid root path", func(t *testing.T) {
+		validRoot := []string{
+			"",
+			"/",
+			"//",
+		}
+
+		for _, n := range validRoot {
+			p, err := newSecretPath(n)
+			assert.NoError(t, err)
+			assert.NotNil(t, p)
+			assert.True(t, p.IsDir())
+			assert.True(t, p.IsRoot())
+			assert.True(t, p.IsVirtual())
+			assert.Equal(t, "/", p.Absolute())
+			assert.Equal(t, "/", p.Name())
+		}
+	})
+
+	t.Run("valid namespace path", func(t *testing.T) {
+		validNamespace := []string{
+			"default",
+			"/default",
+			"/default/",
+			"default/",
+		}
+
+		for _, n := range validNamespace {
+			p, err := newSecretPath(n)
+			assert.NoError(t, err)
+			assert.NotNil(t, p)
+			assert.True(t, p.IsDir())
+			assert.True(t, p.IsNamespace())
+			assert.True(t, p.IsVirtual())
+			assert.Equal(t, "default", p.Namespace())
+			assert.Equal(t, "default", p.Name())
+			assert.Empty(t, p.Secret())
+			assert.Empty(t, p.Key())
+		}
+	})
+
 	t.Run("valid dir path", func(t *testing.T) {
 		validDir := []string{
 			"default/secret",
@@ -60,6 +97,7 @@ func TestPath(t *testing.T) {
 			assert.NoError(t, err)
 			assert.NotNil(t, p)
 			assert.True(t, p.IsDir())
+			assert.False(t, p.IsVirtual())
 			assert.Equal(t, "default", p.Namespace())
 			assert.Equal(t, "secret", p.Secret())
 			assert.Empty(t, p.Key())
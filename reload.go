@@ -0,0 +1,191 @@
+package secfs
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// DefaultReloadDebounce is the delay after the last change of a key before it is reloaded
+const DefaultReloadDebounce = 100 * time.Millisecond
+
+// Reloader keeps the value decoded from a key up to date. The key is watched with Watch and reloaded
+// after the changes have settled for the debounce delay. If the key can not be read or decoded the last
+// good value is kept and the error is passed to the error callbacks.
+// Callbacks are called one at a time from the goroutine of the Reloader, they must not call Close.
+type Reloader[T any] struct {
+	fsys     afero.Fs
+	name     string
+	decode   func([]byte) (T, error)
+	debounce time.Duration
+
+	value atomic.Pointer[T]
+	raw   []byte // value of the key the current value has been decoded from
+
+	mu        sync.Mutex
+	callbacks []func(T)
+	errbacks  []func(error)
+
+	watcher *Watcher
+	done    chan struct{}
+}
+
+// NewReloader returns a Reloader for the key name which decodes its value with decode.
+// A debounce delay which is not positive means DefaultReloadDebounce.
+// The key has to exist and to be decodable, otherwise the error is returned.
+// If fsys is not a secfs ENOTSUP is returned.
+func NewReloader[T any](fsys afero.Fs, name string, decode func([]byte) (T, error), debounce time.Duration) (*Reloader[T], error) {
+	sp, err := newSecretPath(name)
+	if err != nil {
+		return nil, wrapPathError("Reload", name, err)
+	}
+
+	if sp.IsDir() {
+		return nil, wrapPathError("Reload", name, syscall.EISDIR)
+	}
+
+	if debounce <= 0 {
+		debounce = DefaultReloadDebounce
+	}
+
+	r := &Reloader[T]{
+		fsys:     fsys,
+		name:     name,
+		decode:   decode,
+		debounce: debounce,
+		done:     make(chan struct{}),
+	}
+
+	// the watch is started before the key is read, so no change after the read is missed
+	r.watcher, err = Watch(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.load(); err != nil {
+		_ = r.watcher.Close()
+		return nil, err
+	}
+
+	go r.run()
+
+	return r, nil
+}
+
+// Load returns the latest value decoded successfully
+func (r *Reloader[T]) Load() T {
+	return *r.value.Load()
+}
+
+// OnChange registers f to be called with the new value after the key has been reloaded with a different value
+func (r *Reloader[T]) OnChange(f func(T)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.callbacks = append(r.callbacks, f)
+}
+
+// OnError registers f to be called with the errors of the watch and of the reloads, the last good value is kept
+func (r *Reloader[T]) OnError(f func(error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.errbacks = append(r.errbacks, f)
+}
+
+// Close stops watching the key, a pending reload is dropped
+func (r *Reloader[T]) Close() error {
+	err := r.watcher.Close()
+	<-r.done
+
+	return err
+}
+
+// run reloads the key after the debounce delay following the last event until the watcher is closed
+func (r *Reloader[T]) run() {
+	defer close(r.done)
+
+	var (
+		events = r.watcher.Events
+		errs   = r.watcher.Errors
+		reload <-chan time.Time
+	)
+
+	for events != nil {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+
+			reload = time.After(r.debounce)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+
+			r.fail(err)
+		case <-reload:
+			reload = nil
+
+			changed, err := r.load()
+			if err != nil {
+				r.fail(err)
+				continue
+			}
+
+			if changed {
+				r.notify(r.Load())
+			}
+		}
+	}
+}
+
+// load reads and decodes the key, the value is only stored if the key has been decoded successfully
+// returns true if the value of the key has changed
+func (r *Reloader[T]) load() (bool, error) {
+	raw, err := afero.ReadFile(r.fsys, r.name)
+	if err != nil {
+		return false, err
+	}
+
+	if r.value.Load() != nil && bytes.Equal(raw, r.raw) {
+		return false, nil
+	}
+
+	v, err := r.decode(raw)
+	if err != nil {
+		return false, wrapPathError("Reload", r.name, err)
+	}
+
+	r.raw = raw
+	r.value.Store(&v)
+
+	return true, nil
+}
+
+func (r *Reloader[T]) notify(v T) {
+	r.mu.Lock()
+	callbacks := append([]func(T){}, r.callbacks...)
+	r.mu.Unlock()
+
+	for _, f := range callbacks {
+		f(v)
+	}
+}
+
+func (r *Reloader[T]) fail(err error) {
+	r.mu.Lock()
+	errbacks := append([]func(error){}, r.errbacks...)
+	r.mu.Unlock()
+
+	for _, f := range errbacks {
+		f(err)
+	}
+}
@@ -0,0 +1,108 @@
+package secfstest
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// secretsResource returns the resource of secrets for the actions passed to the faults
+func secretsResource() schema.GroupVersionResource {
+	return corev1.SchemeGroupVersion.WithResource("secrets")
+}
+
+// secretsKind returns the kind of secrets for the list actions passed to the faults
+func secretsKind() schema.GroupVersionKind {
+	return corev1.SchemeGroupVersion.WithKind("Secret")
+}
+
+// clientset applies the faults of f to the requests for secrets before they are passed to the fake clientset
+// The fake clientset calls its reactors with its lock held, faults like Latency would block all other requests.
+type clientset struct {
+	*fake.Clientset
+	f *Fs
+}
+
+func (c *clientset) CoreV1() typedcorev1.CoreV1Interface {
+	return &coreV1{CoreV1Interface: c.Clientset.CoreV1(), f: c.f}
+}
+
+type coreV1 struct {
+	typedcorev1.CoreV1Interface
+	f *Fs
+}
+
+func (c *coreV1) Secrets(namespace string) typedcorev1.SecretInterface {
+	return &secrets{SecretInterface: c.CoreV1Interface.Secrets(namespace), f: c.f, namespace: namespace}
+}
+
+type secrets struct {
+	typedcorev1.SecretInterface
+	f         *Fs
+	namespace string
+}
+
+func (s *secrets) Get(ctx context.Context, name string, opts metav1.GetOptions) (*corev1.Secret, error) {
+	if err := s.f.fault(ctx, k8stesting.NewGetAction(secretsResource(), s.namespace, name)); err != nil {
+		return nil, err
+	}
+
+	return s.SecretInterface.Get(ctx, name, opts)
+}
+
+func (s *secrets) List(ctx context.Context, opts metav1.ListOptions) (*corev1.SecretList, error) {
+	if err := s.f.fault(ctx, k8stesting.NewListAction(secretsResource(), secretsKind(), s.namespace, opts)); err != nil {
+		return nil, err
+	}
+
+	return s.SecretInterface.List(ctx, opts)
+}
+
+func (s *secrets) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	if err := s.f.fault(ctx, k8stesting.NewWatchAction(secretsResource(), s.namespace, opts)); err != nil {
+		return nil, err
+	}
+
+	return s.SecretInterface.Watch(ctx, opts)
+}
+
+func (s *secrets) Create(ctx context.Context, ks *corev1.Secret, opts metav1.CreateOptions) (*corev1.Secret, error) {
+	if err := s.f.fault(ctx, k8stesting.NewCreateAction(secretsResource(), s.namespace, ks)); err != nil {
+		return nil, err
+	}
+
+	return s.SecretInterface.Create(ctx, ks, opts)
+}
+
+func (s *secrets) Update(ctx context.Context, ks *corev1.Secret, opts metav1.UpdateOptions) (*corev1.Secret, error) {
+	if err := s.f.fault(ctx, k8stesting.NewUpdateAction(secretsResource(), s.namespace, ks)); err != nil {
+		return nil, err
+	}
+
+	return s.SecretInterface.Update(ctx, ks, opts)
+}
+
+func (s *secrets) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions,
+	subresources ...string) (*corev1.Secret, error) {
+	a := k8stesting.NewPatchSubresourceAction(secretsResource(), s.namespace, name, pt, data, subresources...)
+	if err := s.f.fault(ctx, a); err != nil {
+		return nil, err
+	}
+
+	return s.SecretInterface.Patch(ctx, name, pt, data, opts, subresources...)
+}
+
+func (s *secrets) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	if err := s.f.fault(ctx, k8stesting.NewDeleteActionWithOptions(secretsResource(), s.namespace, name, opts)); err != nil {
+		return err
+	}
+
+	return s.SecretInterface.Delete(ctx, name, opts)
+}
@@ -0,0 +1,284 @@
+// Package secfstest provides a secfs over a fake clientset for testing code using secfs
+// The filesystem can be seeded with files and secrets, faults like latency, conflicts,
+// Forbidden or timeouts can be injected into the requests to the fake API server.
+package secfstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfinance/secfs"
+	"github.com/postfinance/secfs/backend"
+	"github.com/postfinance/secfs/internal/fakeclient"
+	"github.com/spf13/afero"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Any matches all verbs or names in Inject
+const Any = "*"
+
+// Fs is a secfs over a fake clientset
+// The fake clientset maintains resourceVersions like the API server does.
+type Fs struct {
+	afero.Fs
+
+	// Clientset is the fake clientset the secrets are stored in, its requests are not subject to faults
+	Clientset *fake.Clientset
+
+	mu     sync.Mutex
+	faults []rule
+}
+
+// New returns a secfs with opts over a new fake clientset containing the namespace "default"
+func New(opts ...secfs.Option) *Fs {
+	f := &Fs{
+		Clientset: fakeclient.New(),
+	}
+
+	f.Fs = secfs.New(&clientset{Clientset: f.Clientset, f: f}, opts...)
+
+	return f
+}
+
+// Seed creates the files with their values in the fake clientset, files is keyed by NAMESPACE/SECRET/KEY
+// Missing namespaces and secrets are created like with ManagedSecret, SECRET is the name of the Kubernetes
+// secret including a configured prefix and suffix. Seeding does not depend on the options of the filesystem,
+// e.g. read-only filesystems can be seeded, and is not subject to faults.
+func (f *Fs) Seed(files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		parts := strings.Split(strings.Trim(name, "/"), "/")
+		if len(parts) != 3 {
+			return fmt.Errorf("seed %s: path is not NAMESPACE/SECRET/KEY", name)
+		}
+
+		if err := f.seed(parts[0], parts[1], parts[2], files[name]); err != nil {
+			return fmt.Errorf("seed %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// seed sets key of the secret name to value, the secret is created if it does not exist
+func (f *Fs) seed(namespace, name, key string, value []byte) error {
+	secrets := f.Clientset.CoreV1().Secrets(namespace)
+
+	ks, err := secrets.Get(context.Background(), name, metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		return f.Add(ManagedSecret(namespace, name, map[string][]byte{key: value}))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if ks.Data == nil {
+		ks.Data = make(map[string][]byte)
+	}
+
+	ks.Data[key] = value
+
+	_, err = secrets.Update(context.Background(), ks, metav1.UpdateOptions{})
+
+	return err
+}
+
+// AddNamespace creates namespace if it does not exist
+func (f *Fs) AddNamespace(namespace string) error {
+	_, err := f.Clientset.CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace,
+		},
+	}, metav1.CreateOptions{})
+	if apierr.IsAlreadyExists(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Add creates the secrets and their namespaces in the fake clientset, see ManagedSecret and UnmanagedSecret
+func (f *Fs) Add(secrets ...*corev1.Secret) error {
+	for _, ks := range secrets {
+		if err := f.AddNamespace(ks.Namespace); err != nil {
+			return err
+		}
+
+		if _, err := f.Clientset.CoreV1().Secrets(ks.Namespace).Create(context.Background(), ks, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ManagedSecret returns a secret managed with secfs
+// name is the name of the Kubernetes secret including a configured prefix and suffix.
+func ManagedSecret(namespace, name string, data map[string][]byte) *corev1.Secret {
+	ks := UnmanagedSecret(namespace, name, data)
+	ks.Annotations = map[string]string{
+		backend.AnnotationKey: backend.AnnotationValue,
+		backend.ModTimeKey:    time.Now().Format(time.RFC3339Nano),
+	}
+
+	return ks
+}
+
+// UnmanagedSecret returns a secret not managed with secfs, it can not be opened and is not listed
+func UnmanagedSecret(namespace, name string, data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Data: data,
+	}
+}
+
+// Fault is injected into a request to the fake API server
+// ctx is the context of the request. A nil error lets the request pass, e.g. after a delay.
+type Fault func(ctx context.Context, a k8stesting.Action) error
+
+// rule injects fault into the requests matching verb and name
+type rule struct {
+	verb  string
+	name  string
+	fault Fault
+}
+
+// Inject injects fault into the requests of the filesystem for secrets with verb (get, list, watch, create,
+// update, patch, delete) and name. verb and name can be Any, name is the name of the Kubernetes secret including
+// a configured prefix and suffix. List and watch requests only match Any.
+// Faults injected later are applied first. The faults are applied before the request is passed to the fake
+// clientset, requests to Clientset are not affected.
+func (f *Fs) Inject(verb, name string, fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.faults = append([]rule{{verb: verb, name: name, fault: fault}}, f.faults...)
+}
+
+// fault applies the matching faults to the request a until one fails it
+func (f *Fs) fault(ctx context.Context, a k8stesting.Action) error {
+	f.mu.Lock()
+	faults := f.faults
+	f.mu.Unlock()
+
+	for _, r := range faults {
+		if r.verb != Any && r.verb != a.GetVerb() {
+			continue
+		}
+
+		if r.name != Any && r.name != actionName(a) {
+			continue
+		}
+
+		if err := r.fault(ctx, a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Latency delays the requests by d
+// Requests whose context is done before fail with the error of the context, like with the Kubernetes client,
+// e.g. context.DeadlineExceeded if the delay exceeds the request timeout.
+func Latency(d time.Duration) Fault {
+	return func(ctx context.Context, _ k8stesting.Action) error {
+		t := time.NewTimer(d)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Conflict fails the requests with a Conflict
+func Conflict() Fault {
+	return func(_ context.Context, a k8stesting.Action) error {
+		return apierr.NewConflict(a.GetResource().GroupResource(), actionName(a),
+			errors.New("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+}
+
+// Forbidden fails the requests with Forbidden
+func Forbidden() Fault {
+	return func(_ context.Context, a k8stesting.Action) error {
+		return apierr.NewForbidden(a.GetResource().GroupResource(), actionName(a), errors.New("injected by secfstest"))
+	}
+}
+
+// Timeout fails the requests with a server timeout
+func Timeout() Fault {
+	return func(_ context.Context, _ k8stesting.Action) error {
+		return apierr.NewTimeoutError("injected by secfstest", 0)
+	}
+}
+
+// Error fails the requests with err
+func Error(err error) Fault {
+	return func(_ context.Context, _ k8stesting.Action) error {
+		return err
+	}
+}
+
+// Times applies fault to the first n requests only
+func Times(n int, fault Fault) Fault {
+	var mu sync.Mutex
+
+	return func(ctx context.Context, a k8stesting.Action) error {
+		mu.Lock()
+		apply := n > 0
+		n--
+		mu.Unlock()
+
+		if !apply {
+			return nil
+		}
+
+		return fault(ctx, a)
+	}
+}
+
+// actionName returns the name of the object of the request, empty for list and watch
+func actionName(a k8stesting.Action) string {
+	switch a := a.(type) {
+	case k8stesting.GetAction:
+		return a.GetName()
+	case k8stesting.DeleteAction:
+		return a.GetName()
+	case k8stesting.PatchAction:
+		return a.GetName()
+	case k8stesting.CreateAction:
+		if m, ok := a.GetObject().(metav1.Object); ok {
+			return m.GetName()
+		}
+	case k8stesting.UpdateAction:
+		if m, ok := a.GetObject().(metav1.Object); ok {
+			return m.GetName()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,158 @@
+package secfstest_test
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/postfinance/secfs"
+	"github.com/postfinance/secfs/secfstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeed(t *testing.T) {
+	f := secfstest.New(secfs.WithSecretPrefix("app-"))
+
+	require.NoError(t, f.Seed(map[string][]byte{
+		"default/app-db/username": []byte("user"),
+		"default/app-db/password": []byte("password"),
+		"other/app-tls/tls.crt":   []byte("cert"),
+	}))
+
+	v, err := afero.ReadFile(f, "default/db/password")
+	require.NoError(t, err)
+	require.Equal(t, "password", string(v))
+
+	names, err := afero.ReadDir(f, "/")
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+	require.Equal(t, "other", names[1].Name())
+
+	_, err = afero.ReadFile(f, "other/tls/tls.crt")
+	require.NoError(t, err)
+
+	require.Error(t, f.Seed(map[string][]byte{"default/db": nil}))
+
+	t.Run("read-only", func(t *testing.T) {
+		f := secfstest.New(secfs.WithReadOnly())
+
+		require.NoError(t, f.Seed(map[string][]byte{"default/db/password": []byte("password")}))
+		require.NoError(t, f.Seed(map[string][]byte{"default/db/username": []byte("user")}))
+
+		v, err := afero.ReadFile(f, "default/db/username")
+		require.NoError(t, err)
+		require.Equal(t, "user", string(v))
+
+		require.ErrorIs(t, afero.WriteFile(f, "default/db/password", []byte("new"), 0), secfs.ErrReadOnly)
+	})
+}
+
+func TestFixtures(t *testing.T) {
+	f := secfstest.New()
+
+	require.NoError(t, f.Add(
+		secfstest.ManagedSecret("scratch", "managed", map[string][]byte{"key": []byte("value")}),
+		secfstest.UnmanagedSecret("scratch", "unmanaged", map[string][]byte{"key": []byte("value")}),
+	))
+
+	v, err := afero.ReadFile(f, "scratch/managed/key")
+	require.NoError(t, err)
+	require.Equal(t, "value", string(v))
+
+	_, err = f.Stat("scratch/unmanaged/key")
+	require.Error(t, err)
+
+	names, err := afero.ReadDir(f, "scratch")
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+	require.Equal(t, "managed", names[0].Name())
+}
+
+func TestInject(t *testing.T) {
+	t.Run("Forbidden", func(t *testing.T) {
+		f := secfstest.New()
+		require.NoError(t, f.Seed(map[string][]byte{"default/db/password": []byte("password")}))
+
+		f.Inject("get", "db", secfstest.Forbidden())
+
+		_, err := f.Stat("default/db/password")
+		require.ErrorIs(t, err, fs.ErrPermission)
+
+		require.NoError(t, f.Seed(map[string][]byte{"default/other/key": []byte("value")}))
+		require.NoError(t, f.Mkdir("default/third", 0))
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		f := secfstest.New()
+		require.NoError(t, f.Seed(map[string][]byte{"default/db/password": []byte("password")}))
+
+		f.Inject("patch", secfstest.Any, secfstest.Conflict())
+
+		err := afero.WriteFile(f, "default/db/password", []byte("new"), 0)
+		require.ErrorIs(t, err, secfs.ErrConflict)
+	})
+
+	t.Run("Timeout retried", func(t *testing.T) {
+		f := secfstest.New(secfs.WithRetry(3, time.Millisecond))
+		require.NoError(t, f.Seed(map[string][]byte{"default/db/password": []byte("password")}))
+
+		f.Inject("get", "db", secfstest.Times(2, secfstest.Timeout()))
+
+		v, err := afero.ReadFile(f, "default/db/password")
+		require.NoError(t, err)
+		require.Equal(t, "password", string(v))
+
+		f.Inject(secfstest.Any, secfstest.Any, secfstest.Timeout())
+
+		_, err = f.Stat("default/db/password")
+		require.ErrorIs(t, err, syscall.ETIMEDOUT)
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		f := secfstest.New(secfs.WithTimeout(10 * time.Millisecond))
+		require.NoError(t, f.Seed(map[string][]byte{"default/db/password": []byte("password")}))
+
+		f.Inject("create", secfstest.Any, secfstest.Latency(time.Second))
+
+		start := time.Now()
+		require.ErrorIs(t, f.Mkdir("default/slow", 0), syscall.ETIMEDOUT)
+		require.Less(t, time.Since(start), time.Second)
+
+		f = secfstest.New(secfs.WithTimeout(time.Second))
+		require.NoError(t, f.Seed(map[string][]byte{"default/db/password": []byte("password")}))
+
+		f.Inject("create", secfstest.Any, secfstest.Latency(20*time.Millisecond))
+
+		start = time.Now()
+		require.NoError(t, f.Mkdir("default/slow", 0))
+		require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+		// a delayed request does not block the others
+		f.Inject("get", "slow", secfstest.Latency(500*time.Millisecond))
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := f.Stat("default/slow")
+			done <- err
+		}()
+
+		start = time.Now()
+		_, err := f.Stat("default/db/password")
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+		require.NoError(t, <-done)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		f := secfstest.New()
+		require.NoError(t, f.Seed(map[string][]byte{"default/db/password": []byte("password")}))
+
+		injected := errors.New("injected")
+		f.Inject("delete", "db", secfstest.Error(injected))
+
+		require.ErrorIs(t, f.RemoveAll("default/db"), injected)
+	})
+}
@@ -0,0 +1,220 @@
+package secfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/postfinance/secfs/backend"
+	"github.com/spf13/afero"
+)
+
+// Op describes a set of file operations like fsnotify.Op
+type Op uint32
+
+// Operations of an Event
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename
+)
+
+// String returns the names of the operations like "CREATE|WRITE"
+func (op Op) String() string {
+	names := []string{}
+
+	for _, o := range []struct {
+		op   Op
+		name string
+	}{
+		{Create, "CREATE"},
+		{Write, "WRITE"},
+		{Remove, "REMOVE"},
+		{Rename, "RENAME"},
+	} {
+		if op&o.op != 0 {
+			names = append(names, o.name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "[no events]"
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Event is a change of a secret or key like fsnotify.Event
+// Name is the path of the secret or key, e.g. "namespace/secret/key".
+type Event struct {
+	Name string
+	Op   Op
+}
+
+// Has reports if the event has the operation op
+func (e Event) Has(op Op) bool {
+	return e.Op&op == op
+}
+
+// String returns the event like `"namespace/secret/key": WRITE`
+func (e Event) String() string {
+	return fmt.Sprintf("%q: %s", e.Name, e.Op)
+}
+
+// Watcher sends the events of a watched secret or key like fsnotify.Watcher
+// The events have to be received until the watcher is closed.
+type Watcher struct {
+	// Events sends the changes of the watched secrets and keys
+	Events <-chan Event
+	// Errors sends the errors of the watch, the watch continues
+	Errors <-chan error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Close stops the watch, Events and Errors are closed
+func (w *Watcher) Close() error {
+	w.cancel()
+	<-w.done
+
+	return nil
+}
+
+// Watch returns a Watcher for name, which is a key, a secret (all keys) or a namespace (all secrets).
+// The changes are observed with a Kubernetes watch, the keys are compared between the revisions of a secret:
+// new keys are reported with Create, changed values with Write and removed keys with Remove. A key removed
+// in the same change another key with the same value has been added is reported with Rename, the new key
+// with Create. Rename of a key within a secret is such a single change, keys moved to another secret or
+// renamed by other writers with several changes are reported as created and removed. The events of a change
+// are ordered by key, secrets are reported with Create before and Remove after their keys. Dropped watches
+// are resumed.
+// If fsys is not a secfs or its backend does not implement backend.Watcher ENOTSUP is returned.
+func Watch(fsys afero.Fs, name string) (*Watcher, error) {
+	sfs, ok := fsys.(*secfs)
+	if !ok {
+		return nil, wrapPathError("Watch", name, syscall.ENOTSUP)
+	}
+
+	bw, ok := sfs.backend.(backend.Watcher)
+	if !ok {
+		return nil, wrapPathError("Watch", name, syscall.ENOTSUP)
+	}
+
+	sp, err := newSecretPath(name)
+	if err != nil {
+		return nil, wrapPathError("Watch", name, err)
+	}
+
+	ctx, cancel := context.WithCancel(sfs.ctx)
+
+	ch, err := bw.Watch(ctx, sp)
+	if err != nil {
+		cancel()
+		return nil, wrapPathError("Watch", name, err)
+	}
+
+	events := make(chan Event)
+	errs := make(chan error)
+
+	w := &Watcher{
+		Events: events,
+		Errors: errs,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+		defer close(errs)
+		defer close(events)
+
+		for e := range ch {
+			if e.Err != nil {
+				select {
+				case errs <- wrapPathError("Watch", path.Join(e.Namespace, e.Secret), e.Err):
+				case <-ctx.Done():
+				}
+
+				continue
+			}
+
+			for _, ev := range watchEvents(sp, e) {
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+				}
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// watchEvents returns the events of the change e of a secret for the watched path sp
+func watchEvents(sp *secretPath, e backend.SecretEvent) []Event {
+	dir := path.Join(e.Namespace, e.Secret)
+	events := []Event{}
+
+	if e.Old == nil && sp.IsDir() {
+		events = append(events, Event{Name: dir, Op: Create})
+	}
+
+	changes := backend.Diff(e.Old, e.New)
+	renamed := renames(e.Old, e.New, changes)
+
+	for _, c := range changes {
+		if !sp.IsDir() && c.Key != sp.Key() {
+			continue
+		}
+
+		ev := Event{Name: path.Join(dir, c.Key)}
+
+		switch {
+		case c.Type == backend.Added:
+			ev.Op = Create
+		case c.Type == backend.Modified:
+			ev.Op = Write
+		case renamed[c.Key]:
+			ev.Op = Rename
+		default:
+			ev.Op = Remove
+		}
+
+		events = append(events, ev)
+	}
+
+	if e.New == nil && sp.IsDir() {
+		events = append(events, Event{Name: dir, Op: Remove})
+	}
+
+	return events
+}
+
+// renames returns the removed keys which have been renamed to an added key with the same value
+// keys with a value which is not unique among the added keys are not considered renamed
+func renames(o, n map[string][]byte, changes []backend.Change) map[string]bool {
+	renamed := make(map[string]bool)
+
+	for _, r := range changes {
+		if r.Type != backend.Removed {
+			continue
+		}
+
+		matches := 0
+
+		for _, a := range changes {
+			if a.Type == backend.Added && bytes.Equal(o[r.Key], n[a.Key]) {
+				matches++
+			}
+		}
+
+		renamed[r.Key] = matches == 1
+	}
+
+	return renamed
+}